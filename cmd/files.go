@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/drackthor/ysort/internal/config"
+	"github.com/drackthor/ysort/internal/glob"
+	"github.com/drackthor/ysort/internal/sorter"
+)
+
+// stdinArg is the positional argument that selects standard input.
+const stdinArg = "-"
+
+// fileResult is the outcome of sorting one input file.
+type fileResult struct {
+	path     string // file path, or the display name for stdin
+	stdin    bool
+	original []byte
+	sorted   []byte
+	trace    string // --debug-comments output
+	err      error
+}
+
+// changed reports whether sorting produced different bytes.
+func (r fileResult) changed() bool {
+	return r.err == nil && !bytes.Equal(r.original, r.sorted)
+}
+
+// isYAMLFile reports whether name has a .yaml or .yml extension.
+func isYAMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// isConfigFile reports whether name is one of the config file names, which
+// directory walks and globs leave alone.
+func isConfigFile(name string) bool {
+	return slices.Contains(config.FileNames, filepath.Base(name))
+}
+
+// expandArgs turns positional arguments into a list of files. Directories are
+// walked recursively for .yaml/.yml files (skipping .git and other hidden
+// directories, as globs do), arguments with glob metacharacters are expanded
+// (with "**" support), and anything else is taken as a file path. Config files
+// are only sorted when named as such. Each file appears once, in argument
+// order.
+func expandArgs(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(p string) {
+		clean := filepath.Clean(p)
+		if !seen[clean] {
+			seen[clean] = true
+			files = append(files, clean)
+		}
+	}
+
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			if err := walkYAMLFiles(arg, add); err != nil {
+				return nil, fmt.Errorf("walk %s: %w", arg, err)
+			}
+			continue
+		}
+		if glob.HasMeta(arg) {
+			matches, err := glob.Expand(arg)
+			if err != nil {
+				return nil, fmt.Errorf("expand %s: %w", arg, err)
+			}
+			matches = slices.DeleteFunc(matches, isConfigFile)
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", arg)
+			}
+			for _, m := range matches {
+				add(m)
+			}
+			continue
+		}
+		add(arg)
+	}
+	return files, nil
+}
+
+// walkYAMLFiles calls add for every .yaml/.yml file below dir that is not a
+// config file, leaving out .git and other hidden directories.
+func walkYAMLFiles(dir string, add func(string)) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isYAMLFile(p) && !isConfigFile(p) {
+			add(p)
+		}
+		return nil
+	})
+}
+
+// sortFiles reads and sorts every file on a pool of at most jobs workers;
+// opts[i] holds the options for paths[i]. Results are returned in the order
+// of paths.
+func sortFiles(paths []string, opts []sorter.Options, jobs int) []fileResult {
+	results := make([]fileResult, len(paths))
+	jobs = max(1, min(jobs, len(paths)))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = sortFile(paths[i], opts[i])
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func sortFile(path string, opts sorter.Options) fileResult {
+	r := fileResult{path: path}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		r.err = fmt.Errorf("failed to read input file: %w", err)
+		return r
+	}
+	return sortContent(r, content, opts)
+}
+
+// sortStdin reads all of in and sorts it, reporting it under name.
+func sortStdin(in io.Reader, name string, opts sorter.Options) fileResult {
+	r := fileResult{path: name, stdin: true}
+	content, err := io.ReadAll(in)
+	if err != nil {
+		r.err = fmt.Errorf("failed to read stdin: %w", err)
+		return r
+	}
+	return sortContent(r, content, opts)
+}
+
+func sortContent(r fileResult, content []byte, opts sorter.Options) fileResult {
+	r.original = content
+	// Files are sorted in parallel; each keeps its trace until reported.
+	var trace strings.Builder
+	if debugCmts {
+		opts.DebugComments = &trace
+	}
+	r.sorted, r.err = sorter.SortYAMLWithOptions(content, opts)
+	r.trace = trace.String()
+	if r.err != nil {
+		r.err = fmt.Errorf("failed to sort YAML: %w", r.err)
+	}
+	return r
+}
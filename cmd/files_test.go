@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drackthor/ysort/internal/sorter"
+)
+
+func TestExpandArgs(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.yaml", "sub/b.yml", "sub/notes.txt", ".git/c.yaml", ".github/e.yml", "other/d.yaml", ".ysort.yaml"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("a: 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "directory is walked for YAML files",
+			args: []string{filepath.Join(dir, "sub"), filepath.Join(dir, "a.yaml")},
+			want: []string{filepath.Join(dir, "sub/b.yml"), filepath.Join(dir, "a.yaml")},
+		},
+		{
+			name: "walk skips hidden directories and the config file and dedupes",
+			args: []string{dir, filepath.Join(dir, "a.yaml")},
+			want: []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "other/d.yaml"), filepath.Join(dir, "sub/b.yml")},
+		},
+		{
+			name: "recursive glob",
+			args: []string{filepath.Join(dir, "**/*.yml")},
+			want: []string{filepath.Join(dir, "sub/b.yml")},
+		},
+		{
+			name: "recursive glob skips hidden directories and the config file",
+			args: []string{filepath.Join(dir, "**/*.yaml")},
+			want: []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "other/d.yaml")},
+		},
+		{
+			name: "hidden directory named as such",
+			args: []string{filepath.Join(dir, ".github")},
+			want: []string{filepath.Join(dir, ".github/e.yml")},
+		},
+		{
+			name: "config file named as such",
+			args: []string{filepath.Join(dir, ".ysort.yaml")},
+			want: []string{filepath.Join(dir, ".ysort.yaml")},
+		},
+		{
+			name: "plain file is taken as is",
+			args: []string{filepath.Join(dir, "sub/notes.txt")},
+			want: []string{filepath.Join(dir, "sub/notes.txt")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := expandArgs(tc.args)
+			if err != nil {
+				t.Fatalf("expandArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expandArgs() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := expandArgs([]string{filepath.Join(dir, "*.json")}); err == nil {
+		t.Fatal("expandArgs() should fail when a glob matches nothing")
+	}
+}
+
+func TestSortStdin(t *testing.T) {
+	r := sortStdin(strings.NewReader("b: 1\na: 2\n"), "k8s/app.yaml", sorter.Options{})
+	if r.err != nil {
+		t.Fatalf("sortStdin() error = %v", r.err)
+	}
+	if !r.stdin || r.path != "k8s/app.yaml" {
+		t.Fatalf("sortStdin() reported as %q (stdin=%v)", r.path, r.stdin)
+	}
+	if got := string(r.sorted); got != "a: 2\nb: 1\n" {
+		t.Fatalf("sortStdin() sorted = %q", got)
+	}
+	if !r.changed() {
+		t.Fatal("sortStdin() result should be marked as changed")
+	}
+}
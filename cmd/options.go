@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/drackthor/ysort/internal/config"
+	"github.com/drackthor/ysort/internal/sorter"
+)
+
+// baseOptions builds sort options from the command-line flags alone (K8s
+// root, indentation, excludes); config files are applied on top by configResolver.
+func baseOptions() (sorter.Options, error) {
+	seqStyle, ok := sorter.ParseSequenceIndent(seqIndent)
+	if !ok {
+		return sorter.Options{}, fmt.Errorf("invalid --sequence-indent %q (want auto, indented or indentless)", seqIndent)
+	}
+	if !validIndent(indent) {
+		return sorter.Options{}, fmt.Errorf("invalid --indent %d (must be 0 or %d-%d)", indent, sorter.MinIndent, sorter.MaxIndent)
+	}
+	anchorPolicy, ok := sorter.ParseAnchorPolicy(anchors)
+	if !ok {
+		return sorter.Options{}, fmt.Errorf("invalid --anchors %q (want move or error)", anchors)
+	}
+	dedupePolicy, ok := sorter.ParseDedupePolicy(dedupe)
+	if !ok {
+		return sorter.Options{}, fmt.Errorf("invalid --dedupe %q (want first or last)", dedupe)
+	}
+	endings, ok := sorter.ParseLineEndings(lineEndings)
+	if !ok {
+		return sorter.Options{}, fmt.Errorf("invalid --line-endings %q (want auto, lf or crlf)", lineEndings)
+	}
+	opts := sorter.Options{
+		K8sRoot:        k8sMode,
+		Indent:         indent,
+		SequenceIndent: seqStyle,
+		LineEndings:    endings,
+		KeepBlankLines: keepBlank,
+		MoveBlocks:     moveBlocks,
+		Anchors:        anchorPolicy,
+		Dedupe:         dedupePolicy,
+		Verify:         verify,
+	}
+	for _, e := range excludes {
+		rule, err := sorter.ParseExcludeRule(e)
+		if err != nil {
+			return sorter.Options{}, fmt.Errorf("invalid --exclude %q: %w", e, err)
+		}
+		opts.Exclude = append(opts.Exclude, rule)
+	}
+	return opts, nil
+}
+
+// configResolver picks the config file for each input: the one given with -c,
+// none with --no-config, or else the nearest .ysort.yaml found by walking up
+// from the input's directory. Lookups and parsed files are cached.
+type configResolver struct {
+	base    sorter.Options
+	changed func(flag string) bool  // reports flags set on the command line
+	byDir   map[string]string       // directory -> discovered config path ("" for none)
+	loaded  map[string]*config.File // config path -> parsed file
+}
+
+func newConfigResolver(base sorter.Options, changed func(flag string) bool) *configResolver {
+	return &configResolver{
+		base:    base,
+		changed: changed,
+		byDir:   make(map[string]string),
+		loaded:  make(map[string]*config.File),
+	}
+}
+
+// optionsFor returns the sort options for the input named name. path is the
+// input's file path ("" when unknown, e.g. stdin without --stdin-filepath);
+// config discovery starts at its directory and override globs match against it.
+func (c *configResolver) optionsFor(name, path string) (sorter.Options, error) {
+	dir := "."
+	if path != "" {
+		dir = filepath.Dir(path)
+	}
+	cfgPath, err := c.configPath(dir)
+	if err != nil {
+		return sorter.Options{}, err
+	}
+	if cfgPath == "" {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "%s: no config file\n", name)
+		}
+		return c.base, nil
+	}
+
+	cfg, ok := c.loaded[cfgPath]
+	if !ok {
+		if cfg, err = config.Load(cfgPath); err != nil {
+			return sorter.Options{}, fmt.Errorf("config: %w", err)
+		}
+		c.loaded[cfgPath] = cfg
+	}
+	if cfg == nil {
+		return c.base, nil
+	}
+	settings, matched := cfg.SettingsFor(path)
+	if verbose {
+		msg := fmt.Sprintf("%s: using config %s", name, cfgPath)
+		if len(matched) > 0 {
+			msg += fmt.Sprintf(" (overrides %v)", matched)
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	opts, err := c.applySettings(settings)
+	if err != nil {
+		return sorter.Options{}, fmt.Errorf("config %s: %w", cfgPath, err)
+	}
+	return opts, nil
+}
+
+func (c *configResolver) configPath(dir string) (string, error) {
+	switch {
+	case configPath != "":
+		return configPath, nil
+	case noConfig:
+		return "", nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("config: %w", err)
+	}
+	if p, ok := c.byDir[abs]; ok {
+		return p, nil
+	}
+	p, err := config.Discover(abs)
+	if err != nil {
+		return "", fmt.Errorf("config: %w", err)
+	}
+	c.byDir[abs] = p
+	return p, nil
+}
+
+// applySettings returns the base options with the config settings applied.
+// Flags given explicitly on the command line take precedence over the config.
+func (c *configResolver) applySettings(s config.Settings) (sorter.Options, error) {
+	opts := c.base
+	// Copied so that config rules appended for one file don't leak into another's.
+	opts.Exclude = slices.Clone(c.base.Exclude)
+	if err := c.applyLayout(s, &opts); err != nil {
+		return opts, err
+	}
+	if err := c.applyPolicies(s, &opts); err != nil {
+		return opts, err
+	}
+	return opts, applyRules(s, &opts)
+}
+
+// applyLayout applies the settings that shape the output text.
+func (c *configResolver) applyLayout(s config.Settings, opts *sorter.Options) error {
+	if s.Indent != nil && !c.changed("indent") {
+		if !validIndent(*s.Indent) {
+			return fmt.Errorf("invalid indent %d (must be 0 or %d-%d)", *s.Indent, sorter.MinIndent, sorter.MaxIndent)
+		}
+		opts.Indent = *s.Indent
+	}
+	if s.SequenceIndent != "" && !c.changed("sequence-indent") {
+		style, ok := sorter.ParseSequenceIndent(s.SequenceIndent)
+		if !ok {
+			return fmt.Errorf("invalid sequenceIndent %q (want auto, indented or indentless)", s.SequenceIndent)
+		}
+		opts.SequenceIndent = style
+	}
+	if s.LineEndings != "" && !c.changed("line-endings") {
+		endings, ok := sorter.ParseLineEndings(s.LineEndings)
+		if !ok {
+			return fmt.Errorf("invalid lineEndings %q (want auto, lf or crlf)", s.LineEndings)
+		}
+		opts.LineEndings = endings
+	}
+	if s.KeepBlankLines != nil && !c.changed("keep-blank-lines") {
+		opts.KeepBlankLines = *s.KeepBlankLines
+	}
+	return nil
+}
+
+// applyPolicies applies the settings that choose how to sort and what to do
+// about anchors and duplicate keys.
+func (c *configResolver) applyPolicies(s config.Settings, opts *sorter.Options) error {
+	if s.K8s != nil && !c.changed("k8s") {
+		opts.K8sRoot = *s.K8s
+	}
+	if s.MoveBlocks != nil && !c.changed("move-blocks") {
+		opts.MoveBlocks = *s.MoveBlocks
+	}
+	if s.Anchors != "" && !c.changed("anchors") {
+		policy, ok := sorter.ParseAnchorPolicy(s.Anchors)
+		if !ok {
+			return fmt.Errorf("invalid anchors %q (want move or error)", s.Anchors)
+		}
+		opts.Anchors = policy
+	}
+	if s.Dedupe != "" && !c.changed("dedupe") {
+		policy, ok := sorter.ParseDedupePolicy(s.Dedupe)
+		if !ok {
+			return fmt.Errorf("invalid dedupe %q (want first or last)", s.Dedupe)
+		}
+		opts.Dedupe = policy
+	}
+	return nil
+}
+
+// applyRules appends the config's list sort, key order and exclude rules.
+func applyRules(s config.Settings, opts *sorter.Options) error {
+	for _, r := range s.ListSortKeys {
+		rule, err := listSortRule(r)
+		if err != nil {
+			return fmt.Errorf("listSortKeys %q: %w", r.Path, err)
+		}
+		opts.ListSortRules = append(opts.ListSortRules, rule)
+	}
+	for _, r := range s.KeyOrder {
+		if r.Path == "" {
+			return fmt.Errorf("keyOrder: path is required")
+		}
+		opts.KeyOrder = append(opts.KeyOrder, sorter.KeyOrderRule{Path: r.Path, First: r.First, Last: r.Last})
+	}
+	for _, r := range s.Exclude {
+		if r.Path == "" {
+			return fmt.Errorf("exclude: path is required")
+		}
+		scope, ok := sorter.ParseExcludeScope(r.Scope)
+		if !ok {
+			return fmt.Errorf("exclude %q: invalid scope %q (want subtree or node)", r.Path, r.Scope)
+		}
+		opts.Exclude = append(opts.Exclude, sorter.ExcludeRule{Path: r.Path, Scope: scope})
+	}
+	return nil
+}
+
+// listSortRule converts a config list sort rule to the sorter's form.
+func listSortRule(r config.ListSortRule) (sorter.ListSortRule, error) {
+	rule := sorter.ListSortRule{Path: r.Path, Keys: r.SortKeys()}
+	if r.Key != "" && len(r.Keys) > 0 {
+		return rule, fmt.Errorf("set either key or keys, not both")
+	}
+	var ok bool
+	if rule.By, ok = sorter.ParseSortBy(r.By); !ok {
+		return rule, fmt.Errorf("invalid by %q (want key or value)", r.By)
+	}
+	switch {
+	case rule.By == sorter.SortByValue && len(rule.Keys) > 0:
+		return rule, fmt.Errorf("key and keys cannot be used with by: value")
+	case rule.By == sorter.SortByKey && len(rule.Keys) == 0:
+		return rule, fmt.Errorf("key or keys is required")
+	}
+	if rule.Compare, ok = sorter.ParseComparator(r.Compare); !ok {
+		return rule, fmt.Errorf("invalid compare %q (want string, numeric, natural, semver, ip, cidr, k8s-version or quantity)", r.Compare)
+	}
+	if rule.Order, ok = sorter.ParseOrder(r.Order); !ok {
+		return rule, fmt.Errorf("invalid order %q (want asc or desc)", r.Order)
+	}
+	if rule.Missing, ok = sorter.ParseMissingPosition(r.Missing); !ok {
+		return rule, fmt.Errorf("invalid missing %q (want first, last or error)", r.Missing)
+	}
+	if rule.TieBreak, ok = sorter.ParseTieBreak(r.TieBreak); !ok {
+		return rule, fmt.Errorf("invalid tieBreak %q (want none or content)", r.TieBreak)
+	}
+	return rule, nil
+}
+
+// validIndent reports whether n is an indentation width the output can use,
+// or 0 to detect it from the input.
+func validIndent(n int) bool {
+	return n == 0 || (n >= sorter.MinIndent && n <= sorter.MaxIndent)
+}
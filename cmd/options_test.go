@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drackthor/ysort/internal/config"
+	"github.com/drackthor/ysort/internal/sorter"
+)
+
+func TestApplySettings(t *testing.T) {
+	base := sorter.Options{Indent: 2, Anchors: sorter.AnchorError}
+	k8s, four := true, 4
+	settings := config.Settings{K8s: &k8s, Indent: &four, Anchors: "move"}
+
+	t.Run("config applies where no flag is given", func(t *testing.T) {
+		r := newConfigResolver(base, func(string) bool { return false })
+		opts, err := r.applySettings(settings)
+		if err != nil {
+			t.Fatalf("applySettings() error = %v", err)
+		}
+		if !opts.K8sRoot || opts.Indent != 4 || opts.Anchors != sorter.AnchorMove {
+			t.Errorf("applySettings() = %+v, want the config values", opts)
+		}
+	})
+
+	t.Run("flags override the config", func(t *testing.T) {
+		r := newConfigResolver(base, func(flag string) bool { return flag == "indent" || flag == "anchors" })
+		opts, err := r.applySettings(settings)
+		if err != nil {
+			t.Fatalf("applySettings() error = %v", err)
+		}
+		if !opts.K8sRoot || opts.Indent != 2 || opts.Anchors != sorter.AnchorError {
+			t.Errorf("applySettings() = %+v, want k8s from the config and indent and anchors from the flags", opts)
+		}
+	})
+
+	one := 1
+	invalid := []struct {
+		name     string
+		settings config.Settings
+		wantErr  string
+	}{
+		{name: "indent", settings: config.Settings{Indent: &one}, wantErr: "invalid indent 1"},
+		{name: "sequence indent", settings: config.Settings{SequenceIndent: "tabs"}, wantErr: `invalid sequenceIndent "tabs"`},
+		{name: "line endings", settings: config.Settings{LineEndings: "cr"}, wantErr: `invalid lineEndings "cr"`},
+		{name: "anchors", settings: config.Settings{Anchors: "keep"}, wantErr: `invalid anchors "keep"`},
+		{name: "dedupe", settings: config.Settings{Dedupe: "both"}, wantErr: `invalid dedupe "both"`},
+		{
+			name:     "list sort rule",
+			settings: config.Settings{ListSortKeys: []config.ListSortRule{{Path: "items", Key: "name", Compare: "length"}}},
+			wantErr:  `listSortKeys "items": invalid compare "length"`,
+		},
+		{
+			name:     "exclude scope",
+			settings: config.Settings{Exclude: []config.ExcludeRule{{Path: "a", Scope: "tree"}}},
+			wantErr:  `exclude "a": invalid scope "tree"`,
+		},
+	}
+	for _, tc := range invalid {
+		t.Run("invalid "+tc.name, func(t *testing.T) {
+			r := newConfigResolver(base, func(string) bool { return false })
+			_, err := r.applySettings(tc.settings)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("applySettings() error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
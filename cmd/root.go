@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 
-	"github.com/drackthor/ysort/internal/config"
+	"github.com/drackthor/ysort/internal/diff"
 	"github.com/drackthor/ysort/internal/sorter"
 	appversion "github.com/drackthor/ysort/internal/version"
 	"github.com/spf13/cobra"
@@ -16,13 +21,41 @@ var (
 	k8sMode     bool
 	configPath  string
 	showVersion bool
+	indent      int
+	seqIndent   string
+	check       bool
+	showDiff    bool
+	colorMode   string
+	jobs        int
+	stdinPath   string
+	noConfig    bool
+	verbose     bool
+	excludes    []string
+	anchors     string
+	dedupe      string
+	keepBlank   bool
+	moveBlocks  bool
+	lineEndings string
+	debugCmts   bool
+	verify      bool
 )
 
+// exitCodeNotSorted is the process exit code when --check finds a file that
+// would change. Other errors exit with 1.
+const exitCodeNotSorted = 2
+
+// errNotSorted is returned by --check when the input is not sorted.
+var errNotSorted = errors.New("not sorted")
+
 var rootCmd = &cobra.Command{
-	Use:   defaultCommandName + " [file]",
+	Use:   defaultCommandName + " [file|dir|glob]...",
 	Short: "A tool to sort YAML files",
 	Long: `ysort is a CLI tool that sorts YAML files alphabetically
-by their keys while preserving the structure and comments where possible.`,
+by their keys while preserving the structure and comments where possible.
+
+Arguments may be files, directories (searched recursively for .yaml/.yml
+files) or glob patterns such as 'k8s/**/*.yaml'. With no argument, or "-",
+YAML is read from stdin and the sorted result written to stdout.`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if showVersion {
 			if len(args) != 0 {
@@ -30,81 +63,251 @@ by their keys while preserving the structure and comments where possible.`,
 			}
 			return nil
 		}
-		return cobra.ExactArgs(1)(cmd, args)
+		if len(args) > 1 && slices.Contains(args, stdinArg) {
+			return fmt.Errorf("%q (stdin) cannot be combined with other arguments", stdinArg)
+		}
+		return nil
 	},
-	RunE: func(_ *cobra.Command, args []string) error {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if showVersion {
 			fmt.Println(appversion.String())
 			return nil
 		}
 
-		inputFile := args[0]
-
 		// Validate flags
 		if inplace && output != "" {
 			return fmt.Errorf("cannot use both -i and -o flags together")
 		}
-
-		// Read input file
-		content, err := os.ReadFile(inputFile)
+		if (check || showDiff) && (inplace || output != "") {
+			return fmt.Errorf("--check and --diff cannot be combined with -i or -o")
+		}
+		if jobs < 0 {
+			return fmt.Errorf("invalid --jobs %d (must be 0 or positive)", jobs)
+		}
+		if jobs == 0 {
+			jobs = runtime.NumCPU()
+		}
+		if inplace && !cmd.Flags().Changed("verify") {
+			// Never overwrite a file with output that was not checked.
+			verify = true
+		}
+		colorize, err := useColor(colorMode)
+		if err != nil {
+			return err
+		}
+		base, err := baseOptions()
 		if err != nil {
-			return fmt.Errorf("failed to read input file: %w", err)
+			return err
 		}
+		resolver := newConfigResolver(base, cmd.Flags().Changed)
 
-		// Build sort options (K8s root + optional list sort keys from config)
-		opts := sorter.Options{K8sRoot: k8sMode}
-		if configPath != "" {
-			cfg, loadErr := config.Load(configPath)
-			if loadErr != nil {
-				return fmt.Errorf("config: %w", loadErr)
+		var results []fileResult
+		if len(args) == 0 || args[0] == stdinArg {
+			if inplace {
+				return fmt.Errorf("-i cannot be used when reading from stdin")
+			}
+			if len(args) == 0 && stdinIsTerminal() {
+				return fmt.Errorf("no input: pass files or pipe YAML on stdin")
 			}
-			if cfg != nil && len(cfg.ListSortKeys) > 0 {
-				opts.ListSortKeys = make(map[string]string, len(cfg.ListSortKeys))
-				for _, r := range cfg.ListSortKeys {
-					opts.ListSortKeys[r.Path] = r.Key
+			opts, err := resolver.optionsFor(stdinName(), stdinPath)
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			results = []fileResult{sortStdin(os.Stdin, stdinName(), opts)}
+		} else {
+			files, err := expandArgs(args)
+			if err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				return fmt.Errorf("no YAML files found in %s", strings.Join(args, ", "))
+			}
+			if len(files) > 1 && !inplace && !check && !showDiff {
+				return fmt.Errorf("%d files given: use -i, --check or --diff to process more than one file", len(files))
+			}
+			opts := make([]sorter.Options, len(files))
+			for i, f := range files {
+				if opts[i], err = resolver.optionsFor(f, f); err != nil {
+					return err
 				}
 			}
-		}
-		sorted, err := sorter.SortYAMLWithOptions(content, opts)
-		if err != nil {
-			return fmt.Errorf("failed to sort YAML: %w", err)
+			cmd.SilenceUsage = true
+			results = sortFiles(files, opts, jobs)
 		}
 
-		// Write output
-		if inplace {
-			if err := os.WriteFile(inputFile, sorted, 0644); err != nil {
-				return fmt.Errorf("failed to write to file: %w", err)
+		var sum summary
+		for _, r := range results {
+			if err := report(r, colorize); err != nil {
+				if r.stdin {
+					return fmt.Errorf("%s: %w", r.path, err)
+				}
+				if len(results) == 1 {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "%s: %v\n", r.path, err)
+				sum.failed++
+				continue
 			}
-			fmt.Printf("Successfully sorted %s in-place\n", inputFile)
-		} else if output != "" {
-			if err := os.WriteFile(output, sorted, 0644); err != nil {
-				return fmt.Errorf("failed to write output file: %w", err)
+			if r.changed() {
+				sum.changed++
+			} else {
+				sum.unchanged++
 			}
-			fmt.Printf("Successfully sorted %s -> %s\n", inputFile, output)
-		} else {
-			// Write to stdout
-			fmt.Print(string(sorted))
+		}
+		if len(results) > 1 {
+			fmt.Fprintln(os.Stderr, sum)
 		}
 
+		if sum.failed > 0 {
+			return fmt.Errorf("%d of %d files failed", sum.failed, len(results))
+		}
+		if check && sum.changed > 0 {
+			cmd.SilenceErrors = true
+			if len(results) == 1 {
+				return fmt.Errorf("%s: %w", results[0].path, errNotSorted)
+			}
+			return fmt.Errorf("%d of %d files %w", sum.changed, len(results), errNotSorted)
+		}
 		return nil
 	},
 }
 
+// report writes the outcome of one file according to the output mode:
+// --check/--diff print what would change, -i rewrites changed files, -o
+// writes the output file, and otherwise the sorted YAML goes to stdout.
+func report(r fileResult, colorize bool) error {
+	for _, line := range strings.Split(strings.TrimSuffix(r.trace, "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", r.path, line)
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	switch {
+	case check || showDiff:
+		if !r.changed() {
+			return nil
+		}
+		if showDiff {
+			d := diff.Unified(diffLabel("a", r.path), diffLabel("b", r.path), r.original, r.sorted)
+			if colorize {
+				d = diff.Colorize(d)
+			}
+			fmt.Print(d)
+		} else {
+			fmt.Println(r.path)
+		}
+	case inplace:
+		if !r.changed() {
+			return nil
+		}
+		if err := os.WriteFile(r.path, r.sorted, 0644); err != nil {
+			return fmt.Errorf("failed to write to file: %w", err)
+		}
+		fmt.Printf("Successfully sorted %s in-place\n", r.path)
+	case output != "":
+		if err := os.WriteFile(output, r.sorted, 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		fmt.Printf("Successfully sorted %s -> %s\n", r.path, output)
+	default:
+		fmt.Print(string(r.sorted))
+	}
+	return nil
+}
+
+// summary counts per-file outcomes of a multi-file run.
+type summary struct {
+	changed, unchanged, failed int
+}
+
+func (s summary) String() string {
+	verb := "changed"
+	if check || showDiff {
+		verb = "would change"
+	}
+	return fmt.Sprintf("%d files: %d %s, %d unchanged, %d failed",
+		s.changed+s.unchanged+s.failed, s.changed, verb, s.unchanged, s.failed)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode is the process exit code for an error returned by the command.
+func exitCode(err error) int {
+	if errors.Is(err, errNotSorted) {
+		return exitCodeNotSorted
 	}
+	return 1
 }
 
 func init() {
-	rootCmd.Use = commandNameFromArg0(os.Args[0]) + " [file]"
+	rootCmd.Use = commandNameFromArg0(os.Args[0]) + " [file|dir|glob]..."
 
-	rootCmd.Flags().BoolVarP(&inplace, "inplace", "i", false, "sort file in-place, replacing the original file")
+	rootCmd.Flags().BoolVarP(&inplace, "inplace", "i", false, "sort files in-place, replacing the originals")
 	rootCmd.Flags().StringVarP(&output, "output", "o", "", "write sorted output to specified file")
 	rootCmd.Flags().BoolVarP(&k8sMode, "k8s", "k", false, "Kubernetes manifest mode: root keys in fixed order (apiVersion, kind, metadata, spec, …), rest alphabetical")
-	rootCmd.Flags().StringVarP(&configPath, "config", "c", "", "config file defining list sort keys (e.g. sort spec.egress by name)")
+	rootCmd.Flags().StringVarP(&configPath, "config", "c", "", "config file with list sort keys and per-file overrides (default: nearest .ysort.yaml/.ysort.yml above each file)")
+	rootCmd.Flags().BoolVar(&noConfig, "no-config", false, "do not look for a .ysort.yaml config file")
+	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print which config file is applied to each input")
+	rootCmd.Flags().BoolVar(&check, "check", false, "write nothing; print files that sorting would change and exit with code 2 if there are any")
+	rootCmd.Flags().BoolVar(&showDiff, "diff", false, "write nothing; print a unified diff of the changes sorting would make")
+	rootCmd.Flags().StringVar(&colorMode, "color", "auto", "colorize --diff output: auto, always or never")
+	rootCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "keep the node at this path unsorted; append :node to sort what is inside it (repeatable)")
+	rootCmd.Flags().IntVar(&indent, "indent", 0, "spaces per indentation level in the output, 2-9 (0 = detect from input)")
+	rootCmd.Flags().StringVar(&seqIndent, "sequence-indent", "auto", "indentation of lists under a key: auto (detect from input), indented or indentless")
+	rootCmd.Flags().StringVar(&anchors, "anchors", "move", "when sorting would put an alias before its anchor: move (the anchor definition) or error")
+	rootCmd.Flags().StringVar(&dedupe, "dedupe", "", "keep one occurrence of a duplicate mapping key instead of failing: first or last")
+	rootCmd.Flags().StringVar(&lineEndings, "line-endings", "auto", "line breaks of the output: auto (same as the input), lf or crlf")
+	rootCmd.Flags().BoolVar(&keepBlank, "keep-blank-lines", false, "keep a blank line above each key or list item that had one, moving it with the node")
+	rootCmd.Flags().BoolVar(&verify, "verify", false, "decode the output again and refuse to write it unless it holds the same data as the input (default true with -i)")
+	rootCmd.Flags().BoolVar(&debugCmts, "debug-comments", false, "print to stderr which node each comment was attached to")
+	rootCmd.Flags().BoolVar(&moveBlocks, "move-blocks", false, "move the original lines of each key and list item instead of rewriting the file, so unmoved lines stay byte for byte")
+	rootCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "number of files to sort in parallel (0 = number of CPUs)")
+	rootCmd.Flags().StringVar(&stdinPath, "stdin-filepath", "", "path of the file being piped on stdin, used in messages, diff headers and config lookup")
 	rootCmd.Flags().BoolVar(&showVersion, "version", false, "print ysort version and exit")
 	rootCmd.AddCommand(newVersionCommand())
 }
+
+// useColor resolves the --color flag; "auto" colors only when stdout is a terminal.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid --color %q (want auto, always or never)", mode)
+}
+
+// stdinIsTerminal reports whether stdin is attached to a terminal rather than a pipe or file.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// stdinName is the name stdin input is reported under: --stdin-filepath if
+// given, otherwise "<stdin>".
+func stdinName() string {
+	if stdinPath != "" {
+		return stdinPath
+	}
+	return "<stdin>"
+}
+
+// diffLabel builds a diff header name such as "a/dir/file.yaml", the form
+// patch -p1 and git apply expect.
+func diffLabel(prefix, path string) string {
+	return prefix + "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+}
@@ -1,6 +1,12 @@
 package cmd
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestCommandNameFromArg0(t *testing.T) {
 	tests := []struct {
@@ -44,3 +50,57 @@ func TestCommandNameFromArg0(t *testing.T) {
 		})
 	}
 }
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"sorted.yaml":   "a: 1\nb: 2\n",
+		"unsorted.yaml": "b: 2\na: 1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check, noConfig = true, true
+	t.Cleanup(func() { check, noConfig, jobs = false, false, 0 })
+
+	var err error
+	out := captureStdout(t, func() {
+		err = rootCmd.RunE(rootCmd, []string{dir})
+	})
+	if !errors.Is(err, errNotSorted) || exitCode(err) != exitCodeNotSorted {
+		t.Fatalf("--check error = %v (exit code %d), want exit code %d", err, exitCode(err), exitCodeNotSorted)
+	}
+	if want := filepath.Join(dir, "unsorted.yaml") + "\n"; out != want {
+		t.Errorf("--check printed %q, want %q", out, want)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("--check wrote %s: %q", name, got)
+		}
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
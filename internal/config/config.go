@@ -3,21 +3,135 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/drackthor/ysort/internal/glob"
 	"gopkg.in/yaml.v3"
 )
 
+// FileNames are the config file names looked up by Discover, in order of preference.
+var FileNames = []string{".ysort.yaml", ".ysort.yml"}
+
 // File holds the ysort configuration (e.g. from .ysort.yaml).
 type File struct {
+	// Settings at the top level apply to every file.
+	Settings `yaml:",inline"`
+	// Overrides apply extra settings to files matching their globs.
+	Overrides []Override `yaml:"overrides"`
+
+	dir string // directory of the config file; override globs are relative to it
+}
+
+// Settings are the sort options a config file (or one of its overrides) can set.
+// Unset fields leave the value from the command line or an earlier level alone.
+type Settings struct {
+	// K8s orders root keys for Kubernetes manifests (same as -k).
+	K8s *bool `yaml:"k8s"`
 	// ListSortKeys defines how to sort lists of objects: for each path (e.g. "spec.egress"),
 	// sort the list by the given key (e.g. "name") within each element.
 	ListSortKeys []ListSortRule `yaml:"listSortKeys"`
+	// KeyOrder pins some keys first or last in the mappings at a path; other
+	// keys are sorted alphabetically between them.
+	KeyOrder []KeyOrderRule `yaml:"keyOrder"`
+	// Exclude keeps the nodes at these paths in their original order (added
+	// to --exclude).
+	Exclude []ExcludeRule `yaml:"exclude"`
+	// Indent is the number of spaces per level (same as --indent).
+	Indent *int `yaml:"indent"`
+	// SequenceIndent is auto, indented or indentless (same as --sequence-indent).
+	SequenceIndent string `yaml:"sequenceIndent"`
+	// LineEndings is auto, lf or crlf (same as --line-endings).
+	LineEndings string `yaml:"lineEndings"`
+	// KeepBlankLines keeps blank lines above keys and list items (same as --keep-blank-lines).
+	KeepBlankLines *bool `yaml:"keepBlankLines"`
+	// MoveBlocks moves the original lines instead of rewriting the file (same as --move-blocks).
+	MoveBlocks *bool `yaml:"moveBlocks"`
+	// Anchors is move or error (same as --anchors).
+	Anchors string `yaml:"anchors"`
+	// Dedupe is first or last (same as --dedupe); unset makes duplicate keys an error.
+	Dedupe string `yaml:"dedupe"`
+}
+
+// Override applies Settings to the files matching Files.
+type Override struct {
+	// Files are glob patterns relative to the config file's directory, e.g.
+	// "k8s/**/*.yaml". A pattern without "/" matches the file name in any directory.
+	Files    Patterns `yaml:"files"`
+	Settings `yaml:",inline"`
+}
+
+// Patterns is a list of globs that may also be written as a single string.
+type Patterns []string
+
+// UnmarshalYAML accepts either a scalar or a sequence of scalars.
+func (p *Patterns) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = Patterns{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
 }
 
 // ListSortRule defines a single rule: sort the list at path by each element's key.
 type ListSortRule struct {
 	Path string `yaml:"path"` // Dot-separated path from root, e.g. "spec.egress"
-	Key  string `yaml:"key"`  // Key inside each list element to sort by, e.g. "name"
+	Key  string `yaml:"key"`  // Key inside each list element to sort by, e.g. "name" or "metadata.name"
+	// Keys sorts by several keys in order, each breaking ties left by the ones
+	// before it, e.g. [protocol, port]. Use either Key or Keys.
+	Keys []string `yaml:"keys"`
+	// By is "key" (default) to sort by Key/Keys, or "value" to sort a list of
+	// scalars by the elements themselves.
+	By string `yaml:"by"`
+	// Compare is the comparator: string (default), numeric, natural, semver,
+	// ip/cidr, k8s-version or quantity.
+	Compare string `yaml:"compare"`
+	// TieBreak orders elements whose keys are equal: none (default, keep
+	// source order) or content (by the element's canonical serialization).
+	TieBreak string `yaml:"tieBreak"`
+	// Order is asc (default) or desc.
+	Order string `yaml:"order"`
+	// Missing is where elements without the key go: first (default) or last,
+	// or error to fail instead.
+	Missing string `yaml:"missing"`
+}
+
+// SortKeys returns the rule's keys, whether given as key or keys.
+func (r ListSortRule) SortKeys() []string {
+	if r.Key != "" {
+		return []string{r.Key}
+	}
+	return r.Keys
+}
+
+// KeyOrderRule pins the order of keys in the mappings at Path.
+type KeyOrderRule struct {
+	Path  string   `yaml:"path"`  // Path to the mappings, e.g. "spec.template.spec.containers[*]", or "." for the root
+	First []string `yaml:"first"` // Keys placed first, in this order
+	Last  []string `yaml:"last"`  // Keys placed last, in this order
+}
+
+// ExcludeRule keeps the node at Path unsorted. It may be written as just the
+// path string.
+type ExcludeRule struct {
+	Path  string `yaml:"path"`  // Path to the node, e.g. "paths" or "**.tasks[*]"
+	Scope string `yaml:"scope"` // subtree (default: nothing below is sorted) or node (only its own order is kept)
+}
+
+// UnmarshalYAML accepts either a path string or a mapping with path and scope.
+func (r *ExcludeRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = ExcludeRule{Path: node.Value}
+		return nil
+	}
+	type plain ExcludeRule
+	return node.Decode((*plain)(r))
 }
 
 // Load reads a config file from path. Returns nil if the file does not exist.
@@ -33,5 +147,115 @@ func Load(path string) (*File, error) {
 	if err := yaml.Unmarshal(data, &f); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	for i, o := range f.Overrides {
+		if len(o.Files) == 0 {
+			return nil, fmt.Errorf("parse config: overrides[%d]: files is required", i)
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		f.dir = filepath.Dir(abs)
+	}
 	return &f, nil
 }
+
+// SettingsFor returns the settings for the file at path: the top-level
+// settings merged with every matching override, in the order they appear.
+// Scalar settings from a later override replace earlier ones; list sort, key
+// order and exclude rules are appended, so a later rule for the same path wins.
+// matched holds the indexes of the overrides that applied. An empty path
+// matches no override.
+func (f *File) SettingsFor(path string) (s Settings, matched []int) {
+	s = f.Settings
+	s.ListSortKeys = append([]ListSortRule(nil), f.ListSortKeys...)
+	s.KeyOrder = append([]KeyOrderRule(nil), f.KeyOrder...)
+	s.Exclude = append([]ExcludeRule(nil), f.Exclude...)
+	if path == "" {
+		return s, nil
+	}
+	rel := path
+	if abs, err := filepath.Abs(path); err == nil && f.dir != "" {
+		if r, err := filepath.Rel(f.dir, abs); err == nil {
+			rel = r
+		}
+	}
+	rel = filepath.ToSlash(rel)
+
+	for i, o := range f.Overrides {
+		if !o.Files.match(rel) {
+			continue
+		}
+		matched = append(matched, i)
+		s.merge(o.Settings)
+	}
+	return s, matched
+}
+
+func (s *Settings) merge(o Settings) {
+	if o.K8s != nil {
+		s.K8s = o.K8s
+	}
+	if o.Indent != nil {
+		s.Indent = o.Indent
+	}
+	if o.SequenceIndent != "" {
+		s.SequenceIndent = o.SequenceIndent
+	}
+	if o.LineEndings != "" {
+		s.LineEndings = o.LineEndings
+	}
+	if o.KeepBlankLines != nil {
+		s.KeepBlankLines = o.KeepBlankLines
+	}
+	if o.MoveBlocks != nil {
+		s.MoveBlocks = o.MoveBlocks
+	}
+	if o.Anchors != "" {
+		s.Anchors = o.Anchors
+	}
+	if o.Dedupe != "" {
+		s.Dedupe = o.Dedupe
+	}
+	s.ListSortKeys = append(s.ListSortKeys, o.ListSortKeys...)
+	s.KeyOrder = append(s.KeyOrder, o.KeyOrder...)
+	s.Exclude = append(s.Exclude, o.Exclude...)
+}
+
+func (p Patterns) match(rel string) bool {
+	for _, pattern := range p {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if glob.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discover returns the path of the config file nearest to dir: it checks dir
+// and then each parent for one of FileNames, stopping after the repository
+// root (a directory containing .git) or the filesystem root. It returns "" if
+// no config file is found.
+func Discover(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("discover config: %w", err)
+	}
+	for {
+		for _, name := range FileNames {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, nil
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	for _, d := range []string{".git", "k8s/apps", "helm"} {
+		if err := os.MkdirAll(filepath.Join(repo, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(p string) string {
+		if err := os.WriteFile(p, []byte("listSortKeys: []\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// A config above the repository root must not be picked up.
+	write(filepath.Join(root, ".ysort.yaml"))
+	repoConfig := write(filepath.Join(repo, ".ysort.yaml"))
+	k8sConfig := write(filepath.Join(repo, "k8s", ".ysort.yml"))
+
+	tests := []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{name: "nearest config wins", dir: filepath.Join(repo, "k8s", "apps"), want: k8sConfig},
+		{name: "walks up to repository root", dir: filepath.Join(repo, "helm"), want: repoConfig},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Discover(tc.dir)
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("Discover(%q) = %q, want %q", tc.dir, got, tc.want)
+			}
+		})
+	}
+
+	if err := os.Remove(repoConfig); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Discover(filepath.Join(repo, "helm"))
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if got != "" {
+		t.Fatalf("Discover() should stop at the repository root, got %q", got)
+	}
+}
+
+func TestSettingsFor(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".ysort.yaml")
+	data := `k8s: false
+listSortKeys:
+  - path: spec.ports
+    key: name
+exclude:
+  - paths
+overrides:
+  - files: "k8s/**/*.yaml"
+    k8s: true
+    sequenceIndent: indentless
+    exclude:
+      - path: spec.template
+        scope: node
+  - files: ["values.yaml", "ci/*.yml"]
+    indent: 4
+    listSortKeys:
+      - path: spec.ports
+        key: port
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	top := []ListSortRule{{Path: "spec.ports", Key: "name"}}
+	tests := []struct {
+		name        string
+		file        string
+		wantMatched []int
+		want        Settings
+	}{
+		{
+			name:        "override adds to the top-level settings",
+			file:        filepath.Join(dir, "k8s", "apps", "web.yaml"),
+			wantMatched: []int{0},
+			want: Settings{
+				K8s:            ptr(true),
+				ListSortKeys:   top,
+				Exclude:        []ExcludeRule{{Path: "paths"}, {Path: "spec.template", Scope: "node"}},
+				SequenceIndent: "indentless",
+			},
+		},
+		{
+			name:        "override list sort rules come after the top-level ones",
+			file:        filepath.Join(dir, "charts", "web", "values.yaml"),
+			wantMatched: []int{1},
+			want: Settings{
+				K8s:          ptr(false),
+				ListSortKeys: append(slices.Clone(top), ListSortRule{Path: "spec.ports", Key: "port"}),
+				Exclude:      []ExcludeRule{{Path: "paths"}},
+				Indent:       ptr(4),
+			},
+		},
+		{
+			name: "pattern with a slash does not match nested files",
+			file: filepath.Join(dir, "ci", "nested", "build.yml"),
+			want: Settings{K8s: ptr(false), ListSortKeys: top, Exclude: []ExcludeRule{{Path: "paths"}}},
+		},
+		{
+			name: "empty path matches no override",
+			want: Settings{K8s: ptr(false), ListSortKeys: top, Exclude: []ExcludeRule{{Path: "paths"}}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, matched := cfg.SettingsFor(tc.file)
+			if !slices.Equal(matched, tc.wantMatched) {
+				t.Fatalf("SettingsFor(%q) matched overrides %v, want %v", tc.file, matched, tc.wantMatched)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("SettingsFor(%q) = %+v, want %+v", tc.file, got, tc.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
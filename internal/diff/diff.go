@@ -0,0 +1,275 @@
+// Package diff renders line-based unified diffs between two byte slices.
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change.
+const contextLines = 3
+
+const noNewline = "\\ No newline at end of file\n"
+
+// ANSI escape sequences used by Colorize.
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	line string // includes the trailing "\n" unless it is the last line without one
+}
+
+// Unified returns a unified diff from a (labelled oldName) to b (labelled
+// newName), or "" when they are equal. The output is accepted by patch and
+// git apply.
+func Unified(oldName, newName string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	ops := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(ops) {
+		writeHunk(&sb, ops, h)
+	}
+	return sb.String()
+}
+
+// Colorize adds ANSI colors to a diff produced by Unified: file headers in
+// bold, hunk headers in cyan, removed lines in red and added lines in green.
+func Colorize(d string) string {
+	if d == "" {
+		return ""
+	}
+	lines := strings.SplitAfter(d, "\n")
+	var sb strings.Builder
+	for _, l := range lines {
+		if l == "" {
+			continue
+		}
+		body := strings.TrimSuffix(l, "\n")
+		color := ""
+		switch {
+		case strings.HasPrefix(l, "--- "), strings.HasPrefix(l, "+++ "):
+			color = colorBold
+		case strings.HasPrefix(l, "@@"):
+			color = colorCyan
+		case strings.HasPrefix(l, "-"):
+			color = colorRed
+		case strings.HasPrefix(l, "+"):
+			color = colorGreen
+		}
+		if color == "" {
+			sb.WriteString(l)
+			continue
+		}
+		sb.WriteString(color + body + colorReset + l[len(body):])
+	}
+	return sb.String()
+}
+
+// splitLines splits s after each "\n"; a final line without one is kept as is.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b (Myers' algorithm).
+// Common leading and trailing lines are matched up front so the search only
+// covers the changed middle.
+func diffLines(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for _, l := range a[:prefix] {
+		ops = append(ops, op{opEqual, l})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, op{opEqual, l})
+	}
+	return ops
+}
+
+func myers(a, b []string) []op {
+	return backtrack(a, b, myersTrace(a, b))
+}
+
+// myersTrace runs the forward search of Myers' algorithm. Its result holds,
+// at [d][k+d], the furthest x reached on diagonal k after d-1 edits.
+func myersTrace(a, b []string) [][]int {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return trace
+			}
+		}
+	}
+	return trace
+}
+
+// backtrack walks the trace backwards to recover the edit script.
+func backtrack(a, b []string, trace [][]int) []op {
+	ops := make([]op, 0, len(a)+len(b))
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		snap := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && snap[k-1+d] < snap[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := snap[prevK+d]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, op{opEqual, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, op{opInsert, b[y-1]})
+		} else {
+			ops = append(ops, op{opDelete, a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, op{opEqual, a[x-1]})
+		x--
+		y--
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// hunk is a half-open range [start, end) of ops to print together.
+type hunk struct {
+	start, end int
+}
+
+// hunks groups changed ops with up to contextLines of surrounding context,
+// merging groups whose context would overlap.
+func hunks(ops []op) []hunk {
+	var out []hunk
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == opEqual {
+			continue
+		}
+		start := max(i-contextLines, 0)
+		end := i + 1
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == opEqual {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*contextLines {
+				end = next
+				continue
+			}
+			end = min(end+contextLines, len(ops))
+			break
+		}
+		if len(out) > 0 && out[len(out)-1].end >= start {
+			out[len(out)-1].end = end
+		} else {
+			out = append(out, hunk{start, end})
+		}
+		i = end - 1
+	}
+	return out
+}
+
+func writeHunk(sb *strings.Builder, ops []op, h hunk) {
+	// Line numbers of the hunk's first line in a and b (1-based).
+	oldLine, newLine := 1, 1
+	for _, o := range ops[:h.start] {
+		if o.kind != opInsert {
+			oldLine++
+		}
+		if o.kind != opDelete {
+			newLine++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, o := range ops[h.start:h.end] {
+		if o.kind != opInsert {
+			oldCount++
+		}
+		if o.kind != opDelete {
+			newCount++
+		}
+	}
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+	for _, o := range ops[h.start:h.end] {
+		sb.WriteByte(byte(o.kind))
+		sb.WriteString(o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			sb.WriteString("\n" + noNewline)
+		}
+	}
+}
+
+// hunkRange formats a hunk range the way GNU diff does: an empty range
+// points at the line before it, and a count of one is omitted.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name:     "equal input",
+			a:        "a: 1\n",
+			b:        "a: 1\n",
+			expected: "",
+		},
+		{
+			name: "moved key",
+			a:    "b: 2\na: 1\n",
+			b:    "a: 1\nb: 2\n",
+			expected: `--- a/f.yaml
++++ b/f.yaml
+@@ -1,2 +1,2 @@
+-b: 2
+ a: 1
++b: 2
+`,
+		},
+		{
+			name: "distant changes get separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "0\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\nx\n",
+			expected: `--- a/f.yaml
++++ b/f.yaml
+@@ -1,4 +1,4 @@
+-1
++0
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++x
+`,
+		},
+		{
+			name: "missing final newline",
+			a:    "b: 2\na: 1",
+			b:    "a: 1\nb: 2\n",
+			expected: `--- a/f.yaml
++++ b/f.yaml
+@@ -1,2 +1,2 @@
++a: 1
+ b: 2
+-a: 1
+\ No newline at end of file
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("a/f.yaml", "b/f.yaml", []byte(tt.a), []byte(tt.b))
+			if got != tt.expected {
+				t.Fatalf("Unified() =\n%s\nwant:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestColorize(t *testing.T) {
+	d := Unified("a/f.yaml", "b/f.yaml", []byte("b: 2\na: 1\n"), []byte("a: 1\nb: 2\n"))
+	got := Colorize(d)
+	for _, want := range []string{
+		colorBold + "--- a/f.yaml" + colorReset + "\n",
+		colorCyan + "@@ -1,2 +1,2 @@" + colorReset + "\n",
+		colorRed + "-b: 2" + colorReset + "\n",
+		colorGreen + "+b: 2" + colorReset + "\n",
+		"\n a: 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Colorize() output missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package glob matches slash-separated file paths against patterns that may
+// contain "**" to span directories.
+package glob
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// HasMeta reports whether pattern contains any glob metacharacters.
+func HasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[`)
+}
+
+// Match reports whether name matches pattern. Both are split on "/"; a "**"
+// segment matches zero or more path segments and every other segment uses
+// path.Match syntax (so "*" never crosses a "/"). A malformed pattern matches
+// nothing.
+func Match(pattern, name string) bool {
+	return matchSegments(split(pattern), split(name))
+}
+
+// Expand returns the files matching pattern in lexical order. Patterns without
+// "**" are resolved with filepath.Glob; otherwise the tree below the pattern's
+// literal directory prefix is walked. The walk skips .git, and other hidden
+// directories unless a segment of pattern below that prefix starts with a dot.
+func Expand(pattern string) ([]string, error) {
+	slashed := filepath.ToSlash(pattern)
+	if !strings.Contains(slashed, "**") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return regularFiles(matches), nil
+	}
+
+	prefix := literalPrefix(slashed)
+	hidden := slices.ContainsFunc(strings.Split(strings.TrimPrefix(slashed, prefix), "/"), isHidden)
+	root := filepath.FromSlash(prefix)
+	var matches []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != root && skipDir(d.Name(), hidden) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !Match(slashed, filepath.ToSlash(p)) {
+			return nil
+		}
+		matches = append(matches, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// skipDir reports whether a walk leaves out the directory called name: .git
+// always, and other hidden directories unless hidden is set.
+func skipDir(name string, hidden bool) bool {
+	return name == ".git" || (!hidden && isHidden(name))
+}
+
+// isHidden reports whether a path segment names a dotfile.
+func isHidden(seg string) bool {
+	return strings.HasPrefix(seg, ".") && seg != "." && seg != ".."
+}
+
+// literalPrefix returns the leading directories of pattern that contain no
+// metacharacters, or "." when the first segment already does.
+func literalPrefix(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	n := 0
+	for n < len(segs)-1 && !HasMeta(segs[n]) {
+		n++
+	}
+	if n == 0 {
+		if strings.HasPrefix(pattern, "/") {
+			return "/"
+		}
+		return "."
+	}
+	prefix := strings.Join(segs[:n], "/")
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
+
+// regularFiles drops directories from paths.
+func regularFiles(paths []string) []string {
+	files := paths[:0]
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+func split(p string) []string {
+	p = path.Clean(filepath.ToSlash(p))
+	if p == "." {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(p, "./"), "/")
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
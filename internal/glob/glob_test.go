@@ -0,0 +1,71 @@
+package glob
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.yaml", "a.yaml", true},
+		{"*.yaml", "dir/a.yaml", false},
+		{"k8s/**/*.yaml", "k8s/a.yaml", true},
+		{"k8s/**/*.yaml", "k8s/apps/web/a.yaml", true},
+		{"k8s/**/*.yaml", "helm/a.yaml", false},
+		{"**/values.yaml", "values.yaml", true},
+		{"**/values.yaml", "charts/web/values.yaml", true},
+		{"charts/*/values.yaml", "charts/web/values.yaml", true},
+		{"charts/*/values.yaml", "charts/web/sub/values.yaml", false},
+		{"./k8s/*.yaml", "k8s/a.yaml", true},
+		{"**", "any/depth/file.yml", true},
+		{"[", "[", false},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.yaml", "sub/b.yaml", ".git/c.yaml", ".github/d.yaml"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("a: 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"**/*.yaml", []string{"a.yaml", "sub/b.yaml"}},
+		{"**/.github/*.yaml", []string{".github/d.yaml"}},
+		{".github/**/*.yaml", []string{".github/d.yaml"}},
+		{"**/.git*/*.yaml", []string{".github/d.yaml"}},
+	}
+	for _, tt := range tests {
+		got, err := Expand(filepath.Join(dir, tt.pattern))
+		if err != nil {
+			t.Fatalf("Expand(%q) error = %v", tt.pattern, err)
+		}
+		want := make([]string, len(tt.want))
+		for i, w := range tt.want {
+			want[i] = filepath.Join(dir, w)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expand(%q) = %v, want %v", tt.pattern, got, want)
+		}
+	}
+}
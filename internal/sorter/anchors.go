@@ -0,0 +1,165 @@
+package sorter
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AnchorPolicy says what to do when sorting would put an alias (*name) before
+// the node that defines its anchor (&name), which YAML does not allow.
+type AnchorPolicy int
+
+const (
+	// AnchorMove moves the anchor definition to the first place that refers
+	// to it; the original definition becomes an alias. An anchor that another
+	// definition of its name would hide from an alias is renamed. The data is
+	// unchanged.
+	AnchorMove AnchorPolicy = iota
+	// AnchorError fails the sort instead.
+	AnchorError
+)
+
+// ParseAnchorPolicy converts a config value ("move", "error") to an AnchorPolicy.
+func ParseAnchorPolicy(s string) (AnchorPolicy, bool) {
+	switch s {
+	case "", "move":
+		return AnchorMove, true
+	case "error":
+		return AnchorError, true
+	}
+	return AnchorMove, false
+}
+
+// isMergeKey reports whether a mapping key is the YAML merge key "<<".
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.Value == "<<" && key.ShortTag() == "!!merge"
+}
+
+// untagMergeKeys clears the resolved tag of plain merge keys, which yaml.v3
+// would otherwise write out as "!!merge <<".
+func untagMergeKeys(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i]; isMergeKey(key) && key.Style&yaml.TaggedStyle == 0 {
+				key.Tag = ""
+			}
+		}
+	}
+	for _, c := range node.Content {
+		untagMergeKeys(c)
+	}
+}
+
+// fixAnchorOrder walks a sorted document in output order and makes sure each
+// anchor is defined before its first alias, as the policy says. An anchor
+// name defined more than once is also checked: when sorting puts another
+// definition of the name between an alias and the node it refers to, the
+// policy renames that node's anchor or fails.
+func fixAnchorOrder(doc *yaml.Node, policy AnchorPolicy) error {
+	f := anchorFixer{
+		policy:  policy,
+		aliases: make(map[*yaml.Node][]*yaml.Node),
+		defined: make(map[*yaml.Node]bool),
+		active:  make(map[string]*yaml.Node),
+		names:   make(map[string]bool),
+	}
+	f.collect(doc)
+	if len(f.aliases) == 0 {
+		return nil
+	}
+	return f.walk(doc)
+}
+
+type anchorFixer struct {
+	policy  AnchorPolicy
+	aliases map[*yaml.Node][]*yaml.Node // anchor node -> aliases referring to it
+	defined map[*yaml.Node]bool         // anchors already written
+	active  map[string]*yaml.Node       // anchor name -> the definition an alias written now refers to
+	names   map[string]bool             // anchor names in the document
+}
+
+// collect records the aliases and anchor names below node.
+func (f *anchorFixer) collect(node *yaml.Node) {
+	if node.Kind == yaml.AliasNode {
+		f.aliases[node.Alias] = append(f.aliases[node.Alias], node)
+		return
+	}
+	if node.Anchor != "" {
+		f.names[node.Anchor] = true
+	}
+	for _, c := range node.Content {
+		f.collect(c)
+	}
+}
+
+func (f *anchorFixer) walk(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		anchor := node.Alias
+		if f.defined[anchor] {
+			return f.checkShadowed(anchor, node)
+		}
+		if f.policy == AnchorError {
+			return fmt.Errorf("line %d: sorting would put alias *%s before its anchor &%s (line %d)", node.Line, node.Value, anchor.Anchor, anchor.Line)
+		}
+		f.moveAnchor(anchor, node)
+	}
+	if node.Anchor != "" {
+		f.defined[node] = true
+		f.active[node.Anchor] = node
+	}
+	for _, c := range node.Content {
+		if err := f.walk(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkShadowed makes sure alias, which refers to anchor, still does when it
+// is read back: another definition of the same name written between the two
+// would take its place. The policy renames anchor or fails.
+func (f *anchorFixer) checkShadowed(anchor, alias *yaml.Node) error {
+	other := f.active[anchor.Anchor]
+	if other == anchor {
+		return nil
+	}
+	if f.policy == AnchorError {
+		return fmt.Errorf("line %d: sorting would put anchor &%s (line %d) between alias *%s and its anchor (line %d)", alias.Line, other.Anchor, other.Line, alias.Value, anchor.Line)
+	}
+	name := anchor.Anchor
+	for n := 2; f.names[name]; n++ {
+		name = fmt.Sprintf("%s_%d", anchor.Anchor, n)
+	}
+	f.names[name] = true
+	anchor.Anchor = name
+	for _, r := range f.aliases[anchor] {
+		r.Value = name
+	}
+	f.active[name] = anchor
+	return nil
+}
+
+// moveAnchor swaps the definition at anchor with the alias at alias. Each
+// position keeps its own comments; the source position goes with the
+// definition, whose block scalar text is read from there. Every other alias
+// is pointed at the new definition.
+func (f *anchorFixer) moveAnchor(anchor, alias *yaml.Node) {
+	def := *anchor
+	anchor.Kind, anchor.Style, anchor.Tag, anchor.Value = yaml.AliasNode, 0, "", def.Anchor
+	anchor.Anchor, anchor.Alias, anchor.Content = "", alias, nil
+	anchor.Line, anchor.Column = alias.Line, alias.Column
+	alias.Kind, alias.Style, alias.Tag, alias.Value = def.Kind, def.Style, def.Tag, def.Value
+	alias.Anchor, alias.Alias, alias.Content = def.Anchor, nil, def.Content
+	alias.Line, alias.Column = def.Line, def.Column
+
+	refs := f.aliases[anchor]
+	delete(f.aliases, anchor)
+	for _, r := range refs {
+		if r != alias {
+			r.Alias = alias
+			f.aliases[alias] = append(f.aliases[alias], r)
+		}
+	}
+	f.aliases[alias] = append(f.aliases[alias], anchor)
+}
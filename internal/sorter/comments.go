@@ -0,0 +1,1053 @@
+package sorter
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Comments are bound to nodes by where they are in the source rather than
+// where yaml.v3 reports them: yaml.v3 hands a comment at the end of a block to
+// the block's last entry, which sorting then moves, and its encoder writes
+// foot comments where they read back as something else. bindComments gives
+// every comment line one owner and writes the comments above an entry as its
+// head comment. The others go through placeholders, like block scalars: a
+// comment at the end of a block becomes the head comment of an extra entry
+// that restore removes again, and one below an entry whose value fits on its
+// line rides along as that line's comment.
+
+// commentToken prefixes the placeholders, unless the input contains it (see
+// uniqueToken); a counter makes each unique.
+const commentToken = "ysort_comment_"
+
+// commentKind says where a comment is written.
+type commentKind int
+
+const (
+	commentHead     commentKind = iota // above an entry, moving with it
+	commentStart                       // at the top of a block, before its first entry
+	commentEnd                         // at the bottom of a block, after its last entry
+	commentBelow                       // below an entry whose value is on its line
+	commentInline                      // at the end of a node's line
+	commentFlow                        // inside a flow collection, left to yaml.v3
+	commentDocument                    // above a document's root, apart from its first key
+	commentFrame                       // in a document's frame, copied as written (see splitFrames)
+)
+
+// commentBinding is a comment block and the node it is written with.
+type commentBinding struct {
+	lines []int // 0-based source lines of the comment, in order
+	texts []string
+	kind  commentKind
+	node  *yaml.Node
+	doc   int
+}
+
+// commentEntry is a mapping entry or list item that comments can belong to.
+type commentEntry struct {
+	node   *yaml.Node // the key, or the list item
+	value  *yaml.Node // the key's value, or the list item
+	parent *yaml.Node // the mapping or sequence holding the entry
+	line   int        // 0-based line of the key or "-"
+	col    int        // 0-based column of the key or "-"
+	doc    int
+}
+
+// sourceComment is a comment found in the text: a whole comment line or the
+// comment at the end of a line.
+type sourceComment struct {
+	line int // 0-based
+	text string
+}
+
+// commentSet is the result of bindComments for one input.
+type commentSet struct {
+	lines    []string // the lines yaml.v3 parsed
+	src      []string // the lines as written
+	blank    blankLines
+	entries  []*commentEntry
+	roots    []int                        // 0-based line of each document's root
+	paths    map[*yaml.Node][]pathSegment // where each node is, for the trace
+	content  map[int]bool                 // lines that belong to a block scalar or flow collection
+	headLow  map[*yaml.Node]int           // first line an entry's head comment may start at
+	fenced   map[*yaml.Node]bool          // documents with a head comment of their own
+	entryOf  map[*yaml.Node]*commentEntry // key or item -> its entry
+	bindings []commentBinding
+	input    []sourceComment
+	below    map[string]heldComment // token -> comment written below its line
+	token    string                 // placeholder prefix
+	tokens   int
+}
+
+// heldComment is a comment block waiting for restore.
+type heldComment struct {
+	text  string
+	alone bool // the token is the line's only comment
+	item  bool // the line holds a list item
+}
+
+// bindComments assigns every comment in the documents to a node, reading
+// their positions from lines, the source as yaml.v3 parsed it (see
+// splitFrames). Head comments are set on the nodes; yaml.v3's foot comments,
+// and its head comments that bindComments did not confirm, are dropped. src is
+// the source as written, whose comments restore and verify account for.
+func bindComments(docs []*yaml.Node, src, lines []string, blank blankLines) *commentSet {
+	s := &commentSet{
+		lines:   lines,
+		src:     src,
+		blank:   blank,
+		paths:   nodePaths(docs),
+		content: make(map[int]bool),
+		headLow: make(map[*yaml.Node]int),
+		fenced:  make(map[*yaml.Node]bool),
+		entryOf: make(map[*yaml.Node]*commentEntry),
+		below:   make(map[string]heldComment),
+		token:   commentToken,
+	}
+	body := newBlockScalars(lines).bodyLines(docs)
+	for l := range body {
+		s.content[l] = true
+	}
+	for l := range flowLines(docs, lines) {
+		s.content[l] = true
+	}
+	scalars := scalarLines(docs, lines)
+	for l := range scalars {
+		s.content[l] = true
+	}
+	for i, doc := range docs {
+		s.roots = append(s.roots, doc.Content[0].Line-1)
+		doc.FootComment = ""
+		s.collect(doc.Content[0], i)
+	}
+	s.input = scanComments(src, body, scalars)
+	s.bindFrames(docs)
+
+	byLine := s.entriesByLine()
+	s.bindGaps(docs, byLine)
+	for _, doc := range docs {
+		if !s.fenced[doc] {
+			doc.HeadComment = ""
+		}
+	}
+
+	for l, es := range byLine {
+		for j, e := range es {
+			e.node.HeadComment = ""
+			if j == 0 {
+				s.bindHead(e, l)
+			}
+		}
+	}
+	sort.SliceStable(s.bindings, func(a, b int) bool { return s.bindings[a].lines[0] < s.bindings[b].lines[0] })
+	return s
+}
+
+// entriesByLine groups the entries by the line they start on, left to right.
+func (s *commentSet) entriesByLine() map[int][]*commentEntry {
+	byLine := make(map[int][]*commentEntry)
+	for _, e := range s.entries {
+		byLine[e.line] = append(byLine[e.line], e)
+	}
+	for _, es := range byLine {
+		sort.SliceStable(es, func(a, b int) bool { return es[a].col < es[b].col })
+	}
+	return byLine
+}
+
+// bindGaps walks the lines, keeping track of the blocks each one is in, and
+// binds every run of blank and comment lines with bindGap.
+func (s *commentSet) bindGaps(docs []*yaml.Node, byLine map[int][]*commentEntry) {
+	var chain []*commentEntry // entries whose block the current line is in
+	gap := -1                 // first line of the current run of blank and comment lines
+	for i := 0; i <= len(s.lines); i++ {
+		if i < len(s.lines) && !s.content[i] && isBlankOrComment(s.lines[i]) {
+			if gap < 0 {
+				gap = i
+			}
+			continue
+		}
+		if gap >= 0 {
+			s.bindGap(docs, gap, i, chain, byLine[i])
+			gap = -1
+		}
+		if i == len(s.lines) {
+			break
+		}
+		if isDocumentMarker(s.lines[i]) {
+			chain = nil
+			continue
+		}
+		for _, e := range byLine[i] {
+			for len(chain) > 0 && chain[len(chain)-1].col >= e.col {
+				chain = chain[:len(chain)-1]
+			}
+			chain = append(chain, e)
+		}
+	}
+}
+
+// collect records the entries below node and drops yaml.v3's head and foot
+// comments on them; bindComments writes its own. Flow collections keep theirs.
+func (s *commentSet) collect(node *yaml.Node, doc int) {
+	node.HeadComment, node.FootComment = "", ""
+	s.inline(node, doc)
+	if node.Style&yaml.FlowStyle != 0 {
+		s.collectFlow(node, doc)
+		return
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			e := &commentEntry{node: key, value: value, parent: node, line: key.Line - 1, col: key.Column - 1, doc: doc}
+			s.entries = append(s.entries, e)
+			s.entryOf[key] = e
+			key.FootComment = ""
+			s.inline(key, doc)
+			s.collect(value, doc)
+		}
+	case yaml.SequenceNode:
+		col := node.Column - 1
+		for _, item := range node.Content {
+			e := &commentEntry{node: item, value: item, parent: node, line: dashLine(s.lines, item, col), col: col, doc: doc}
+			s.entries = append(s.entries, e)
+			s.entryOf[item] = e
+			s.collect(item, doc)
+		}
+	}
+}
+
+// nodePaths returns the path of every node in docs: a key has the path of
+// its value, and a document and its root the empty path.
+func nodePaths(docs []*yaml.Node) map[*yaml.Node][]pathSegment {
+	paths := make(map[*yaml.Node][]pathSegment)
+	var walk func(node *yaml.Node, path []pathSegment)
+	walk = func(node *yaml.Node, path []pathSegment) {
+		paths[node] = path
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				p := append(slices.Clip(path), keySegment(node.Content[i].Value))
+				paths[node.Content[i]] = p
+				walk(node.Content[i+1], p)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				walk(item, append(slices.Clip(path), indexSegment(i)))
+			}
+		default:
+			for _, c := range node.Content {
+				walk(c, path)
+			}
+		}
+	}
+	for _, doc := range docs {
+		walk(doc, nil)
+	}
+	return paths
+}
+
+// flowLines returns the 0-based lines of the flow collections in docs, up to
+// a closing bracket on a line of its own.
+func flowLines(docs []*yaml.Node, lines []string) map[int]bool {
+	flow := make(map[int]bool)
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Style&yaml.FlowStyle == 0 {
+			for _, c := range node.Content {
+				walk(c)
+			}
+			return
+		}
+		l := node.Line - 1
+		for last := lastLine(node); l <= last; l++ {
+			flow[l] = true
+		}
+		for ; l < len(lines) && strings.IndexAny(strings.TrimSpace(lines[l]), "]}") == 0; l++ {
+			flow[l] = true
+		}
+	}
+	for _, doc := range docs {
+		walk(doc)
+	}
+	return flow
+}
+
+// scalarLines maps the 0-based lines after the first of each quoted or plain
+// scalar in docs that spans lines to the column where its text ends on them:
+// after the closing quote, before the " #" of a comment that ends a plain
+// scalar, or the end of the line. Only the rest of such a line can hold a
+// comment.
+func scalarLines(docs []*yaml.Node, lines []string) map[int]int {
+	var starts []int // the line of every node, to bound plain scalars
+	var scalars []*yaml.Node
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		starts = append(starts, node.Line-1)
+		switch {
+		case node.Style&yaml.FlowStyle != 0:
+		case node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0 && node.Value != "":
+			scalars = append(scalars, node)
+		case node.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				starts = append(starts, node.Content[i].Line-1) // a key fits on its line
+				walk(node.Content[i+1])
+			}
+		default:
+			for _, c := range node.Content {
+				walk(c)
+			}
+		}
+	}
+	for _, doc := range docs {
+		walk(doc)
+	}
+	sort.Ints(starts)
+
+	ends := make(map[int]int)
+	for _, node := range scalars {
+		scalarEnds(lines, node, starts, ends)
+	}
+	return ends
+}
+
+// scalarEnds records in ends the lines after the first of node, a quoted or
+// plain scalar. starts are the sorted lines of all nodes.
+func scalarEnds(lines []string, node *yaml.Node, starts []int, ends map[int]int) {
+	l, col := node.Line-1, node.Column-1
+	if l < 0 || l >= len(lines) || col > len(lines[l]) {
+		return
+	}
+	if quote := byte('"'); node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+		if node.Style&yaml.SingleQuotedStyle != 0 {
+			quote = '\''
+		}
+		quotedLines(lines, l, col, quote, ends)
+		return
+	}
+	next := len(lines)
+	if i := sort.SearchInts(starts, l+1); i < len(starts) {
+		next = starts[i]
+	}
+	plainLines(lines, l+1, next, ends)
+}
+
+// quotedLines records in ends the lines after line l of the scalar quoted
+// with quote that opens at or after col.
+func quotedLines(lines []string, l, col int, quote byte, ends map[int]int) {
+	i := strings.IndexByte(lines[l][col:], quote)
+	if i < 0 {
+		return
+	}
+	if _, closed := quoteEnd(lines[l], col+i+1, quote); closed {
+		return
+	}
+	for l++; l < len(lines); l++ {
+		if end, closed := quoteEnd(lines[l], 0, quote); closed {
+			ends[l] = end + 1
+			return
+		}
+		ends[l] = len(lines[l])
+	}
+}
+
+// plainLines records in ends the continuation lines of a plain scalar, from
+// line from up to the line of the next node: it ends at a comment.
+func plainLines(lines []string, from, next int, ends map[int]int) {
+	for l := from; l < next && l < len(lines); l++ {
+		line := lines[l]
+		t := strings.TrimSpace(line)
+		switch {
+		case t == "":
+			continue
+		case strings.HasPrefix(t, "#") || isDocumentMarker(line) || strings.HasPrefix(line, "%"):
+			return
+		}
+		for i := 1; i < len(line); i++ {
+			if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+				ends[l] = i - 1
+				return
+			}
+		}
+		ends[l] = len(line)
+	}
+}
+
+// collectFlow records the comments yaml.v3 found inside a flow collection.
+func (s *commentSet) collectFlow(node *yaml.Node, doc int) {
+	for _, c := range node.Content {
+		for _, text := range []string{c.HeadComment, c.FootComment} {
+			if text != "" {
+				s.bind(commentFlow, c, doc, []int{c.Line - 1}, strings.Split(text, "\n"))
+			}
+		}
+		s.inline(c, doc)
+		s.collectFlow(c, doc)
+	}
+}
+
+// inline records the comment at the end of node's line, which yaml.v3 keeps
+// with the node.
+func (s *commentSet) inline(node *yaml.Node, doc int) {
+	if node.LineComment != "" {
+		s.bind(commentInline, node, doc, []int{node.Line - 1}, strings.Split(node.LineComment, "\n"))
+	}
+}
+
+// bindGap binds the comments on lines from..to-1, which are blank lines and
+// comments. chain holds the entries whose blocks are open above them,
+// outermost first; next the entries that start on line to.
+//
+// Comments indented deeper than the next entry end the block above it: they
+// stay at the end of the innermost block they are indented into, or below an
+// entry whose value is on its line. The rest belong to the next entry. A
+// group of them separated from a block's first entry by a blank line is about
+// the whole block and stays at its top; above a document's root,
+// attachDocumentComments has put a "---" on that blank line.
+func (s *commentSet) bindGap(docs []*yaml.Node, from, to int, chain, next []*commentEntry) {
+	var comments []int
+	for l := from; l < to; l++ {
+		if strings.TrimSpace(s.lines[l]) != "" {
+			comments = append(comments, l)
+		}
+	}
+	if len(comments) == 0 {
+		return
+	}
+	if to < len(s.lines) && isDocumentMarker(s.lines[to]) {
+		if strings.TrimSpace(s.src[to]) == "" {
+			// The fence attachDocumentComments put in.
+			s.bindDocument(docs, to, comments)
+		} else {
+			// Indented comments that splitFrames left above a marker.
+			s.bindTrailing(docs, chain, comments, from)
+		}
+		return
+	}
+
+	var n *commentEntry
+	if len(next) > 0 {
+		n = next[0]
+	}
+	first, split := s.splitGap(chain, n, comments)
+	s.bindTrailing(docs, chain, comments[:split], from)
+	if n == nil || split == len(comments) {
+		if n != nil {
+			s.headLow[n.node] = comments[len(comments)-1] + 1
+		}
+		return
+	}
+	low := from
+	if split > 0 {
+		low = comments[split-1] + 1
+	}
+	if first && len(chain) > 0 {
+		low = s.bindStart(n, comments, low)
+	}
+	s.headLow[n.node] = low
+}
+
+// splitGap reports whether n, the entry after comments, starts the block
+// that is open above them, and returns how many of comments end the blocks in
+// chain; the rest belong to n.
+func (s *commentSet) splitGap(chain []*commentEntry, n *commentEntry, comments []int) (bool, int) {
+	if n == nil {
+		return false, len(comments)
+	}
+	if len(chain) == 0 || chain[len(chain)-1].value == n.parent {
+		return true, 0
+	}
+	for i, l := range comments {
+		if leadingSpaces(s.lines[l]) <= n.col {
+			return false, i
+		}
+	}
+	return false, len(comments)
+}
+
+// bindFrames binds the comments that splitFrames took out of the documents:
+// those next to the "---" and "..." markers and a modeline. Each belongs to
+// the document that follows it, unless a "---" comes first, and after the
+// last one to that.
+func (s *commentSet) bindFrames(docs []*yaml.Node) {
+	for _, c := range s.input {
+		l := c.line
+		if s.lines[l] == s.src[l] || (strings.TrimSpace(s.lines[l]) != "" && !isDocumentMarker(s.lines[l])) {
+			continue
+		}
+		doc := len(docs) - 1
+		for i, root := range s.roots {
+			if root > l {
+				doc = i
+				break
+			}
+		}
+		if doc > 0 && slices.ContainsFunc(s.src[l+1:max(l+1, s.roots[doc])], func(line string) bool {
+			return strings.HasPrefix(line, "---") && isDocumentMarker(line)
+		}) {
+			doc-- // above the "---": the foot of the document before
+		}
+		s.bind(commentFrame, docs[doc], doc, []int{l}, []string{c.text})
+	}
+}
+
+// bindDocument binds comments, which are right above the "---" on line
+// marker, to the document that follows it.
+func (s *commentSet) bindDocument(docs []*yaml.Node, marker int, comments []int) {
+	for i, root := range s.roots {
+		if root > marker {
+			s.fenced[docs[i]] = true
+			s.bind(commentDocument, docs[i], i, comments, s.texts(comments))
+			return
+		}
+	}
+}
+
+// bindTrailing binds comments, which end the blocks in chain, in groups that
+// are written in the same place. from is the first line of their gap.
+func (s *commentSet) bindTrailing(docs []*yaml.Node, chain []*commentEntry, comments []int, from int) {
+	for i := 0; i < len(comments); {
+		kind, node, doc := s.trailing(docs, chain, leadingSpaces(s.lines[comments[i]]), from)
+		j := i + 1
+		for j < len(comments) {
+			k, nd, _ := s.trailing(docs, chain, leadingSpaces(s.lines[comments[j]]), from)
+			if k != kind || nd != node {
+				break
+			}
+			j++
+		}
+		s.bind(kind, node, doc, comments[i:j], s.texts(comments[i:j]))
+		i = j
+	}
+}
+
+// bindStart binds the comments above the last blank line before n, the first
+// entry of its block, to the top of the block, and returns the first line of
+// the comments left for n. Without such a line it returns low.
+func (s *commentSet) bindStart(n *commentEntry, comments []int, low int) int {
+	for l := n.line - 1; l > comments[0]; l-- {
+		if strings.TrimSpace(s.lines[l]) != "" {
+			continue
+		}
+		var top []int
+		for _, c := range comments {
+			if c < l {
+				top = append(top, c)
+			}
+		}
+		s.bind(commentStart, n.parent, n.doc, top, s.texts(top))
+		return l + 1
+	}
+	return low
+}
+
+// trailing returns where a comment at col that ends the blocks in chain is
+// written, and the node it is written with.
+func (s *commentSet) trailing(docs []*yaml.Node, chain []*commentEntry, col, line int) (commentKind, *yaml.Node, int) {
+	if len(chain) == 0 {
+		doc := 0
+		for i, root := range s.roots {
+			if root <= line {
+				doc = i
+			}
+		}
+		return commentEnd, docs[doc].Content[0], doc
+	}
+	deepest := chain[len(chain)-1]
+	if col > deepest.col && !isBlockCollection(deepest.value) {
+		return commentBelow, deepest.node, deepest.doc
+	}
+	owner := chain[0].parent
+	for i, e := range chain {
+		outer := docs[e.doc].Content[0]
+		if i > 0 {
+			outer = chain[i-1].value
+		}
+		switch {
+		case e.col > col:
+		case e.col == col && indentlessItem(outer, e):
+			owner = outer
+		default:
+			owner = e.parent
+		}
+	}
+	return commentEnd, owner, deepest.doc
+}
+
+// indentlessItem reports whether e is an item of a list written at the column
+// of its key, a key of the mapping outer. A comment at that column ends the
+// mapping rather than the list: that is where it is read back once the key is
+// no longer the last.
+func indentlessItem(outer *yaml.Node, e *commentEntry) bool {
+	if e.value != e.node || outer.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(outer.Content); i += 2 {
+		if key := outer.Content[i]; outer.Content[i+1] == e.parent {
+			return key.Column-1 == e.col
+		}
+	}
+	return false
+}
+
+// bindHead sets the head comment of the entry that starts on line l from the
+// comments directly above it, not looking above headLow, and from the comments
+// after a "-" alone on its line that dashComments finds.
+func (s *commentSet) bindHead(e *commentEntry, l int) {
+	low := s.headLow[e.node]
+	var comments []int
+	j := l - 1
+	for ; j >= low && isBlankOrComment(s.lines[j]) && !s.content[j]; j-- {
+		if strings.TrimSpace(s.lines[j]) != "" {
+			comments = append(comments, j)
+		}
+	}
+	if j >= low {
+		low = j + 1 // the end of a block scalar or flow collection
+	}
+	head := extractLeadingCommentBlock(s.lines, l+1, s.blank, low)
+	slices.Reverse(comments)
+	comments, texts, head := s.dashComments(e, l, comments, s.texts(comments), head)
+	e.node.HeadComment = head
+	if len(comments) > 0 {
+		s.bind(commentHead, e.node, e.doc, comments, texts)
+	}
+}
+
+// dashComments adds to the head comment of the entry that starts on line l the
+// comment after a "-" alone on its line: the list item's own if its value is
+// not a block collection, else that of the first entry of the collection.
+func (s *commentSet) dashComments(e *commentEntry, l int, comments []int, texts []string, head string) ([]int, []string, string) {
+	if e.value == e.node && e.value.Line-1 != l && !isBlockCollection(e.value) {
+		if text, ok := s.dashComment(l); ok {
+			comments = append(comments, l)
+			texts = append(texts, text)
+			head = strings.TrimPrefix(head+"\n"+text, "\n")
+		}
+	}
+	if item, ok := s.entryOf[e.parent]; ok && item.value == e.parent && e.parent.Content[0] == e.node && item.line != l {
+		// "- # comment" above the first entry of a list item is that entry's
+		// head comment, as the encoder writes it.
+		if text, ok := s.dashComment(item.line); ok {
+			comments = append([]int{item.line}, comments...)
+			texts = append([]string{text}, texts...)
+			head = strings.TrimSuffix(text+"\n"+head, "\n")
+		}
+	}
+	return comments, texts, head
+}
+
+// dashComment returns the comment after a "-" that line l starts with.
+func (s *commentSet) dashComment(l int) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s.lines[l]), "-")
+	rest = strings.TrimSpace(rest)
+	return rest, ok && strings.HasPrefix(rest, "#")
+}
+
+func (s *commentSet) bind(kind commentKind, node *yaml.Node, doc int, lines []int, texts []string) {
+	s.bindings = append(s.bindings, commentBinding{lines: lines, texts: texts, kind: kind, node: node, doc: doc})
+}
+
+// texts returns the trimmed text of lines.
+func (s *commentSet) texts(lines []int) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = strings.TrimSpace(s.lines[l])
+	}
+	return out
+}
+
+// block returns the comment lines of b as one comment, blank lines between
+// them written like those in head comments. With blank.keep a blank line above
+// the first one is kept too.
+func (s *commentSet) block(b commentBinding) string {
+	first, last := b.lines[0], b.lines[len(b.lines)-1]
+	var lines []string
+	if s.blank.keep && first > 0 && strings.TrimSpace(s.lines[first-1]) == "" {
+		lines = append(lines, "")
+	}
+	for l := first; l <= last; l++ {
+		lines = append(lines, strings.TrimSpace(s.lines[l]))
+	}
+	return s.blank.join(lines)
+}
+
+func isBlockCollection(node *yaml.Node) bool {
+	return (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) && node.Style&yaml.FlowStyle == 0 && len(node.Content) > 0
+}
+
+// hold writes the comments that do not sit above an entry into the sorted
+// documents: at the top of a block as its head comment, at the bottom as the
+// head comment of a placeholder entry, and below an entry as a placeholder
+// line comment.
+func (s *commentSet) hold() {
+	ends := make(map[*yaml.Node][]string)
+	var order []*yaml.Node
+	for _, b := range s.bindings {
+		switch b.kind {
+		case commentStart:
+			text := s.block(b) + "\n" + s.blank.marker
+			if first := b.node.Content[0]; first.HeadComment != "" {
+				// The encoder writes only one of the two head comments.
+				first.HeadComment = text + "\n" + first.HeadComment
+				continue
+			}
+			b.node.HeadComment = text
+		case commentEnd:
+			if _, ok := ends[b.node]; !ok {
+				order = append(order, b.node)
+			}
+			ends[b.node] = append(ends[b.node], s.block(b))
+		case commentBelow:
+			s.holdBelow(b)
+		}
+	}
+	for _, node := range order {
+		text := strings.Join(ends[node], "\n")
+		if !isBlockCollection(node) {
+			node.FootComment = strings.TrimPrefix(node.FootComment+"\n"+text, "\n")
+			continue
+		}
+		token := s.nextToken()
+		placeholder := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: token, HeadComment: text}
+		if node.Kind == yaml.MappingNode {
+			node.Content = append(node.Content, placeholder, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+		} else {
+			node.Content = append(node.Content, placeholder)
+		}
+	}
+}
+
+// holdBelow marks the line of the entry b is bound to with a placeholder
+// line comment.
+func (s *commentSet) holdBelow(b commentBinding) {
+	token := s.nextToken()
+	e := s.entryOf[b.node]
+	target := e.value
+	if e.value.LineComment == "" && e.node.LineComment != "" {
+		target = e.node
+	}
+	held := heldComment{text: s.block(b), alone: target.LineComment == "", item: e.value == e.node}
+	if held.alone {
+		target.LineComment = "# " + token
+	} else {
+		target.LineComment += " " + token
+	}
+	s.below[token] = held
+}
+
+func (s *commentSet) nextToken() string {
+	s.tokens++
+	return fmt.Sprintf("%s%d", s.token, s.tokens)
+}
+
+// restore removes the placeholders from encoder output: a placeholder entry
+// goes, leaving its head comment at the end of its block, and a placeholder
+// line comment makes way for its comment below the line, indent spaces
+// deeper than the line's key or "-".
+func (s *commentSet) restore(out []byte, indent int) []byte {
+	if !strings.Contains(string(out), s.token) {
+		return out
+	}
+	lines := strings.Split(string(out), "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		i := strings.Index(line, s.token)
+		if i < 0 {
+			result = append(result, line)
+			continue
+		}
+		j := i + len(s.token)
+		for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+			j++
+		}
+		held, ok := s.below[line[i:j]]
+		if !ok {
+			continue // a placeholder entry
+		}
+		rest := strings.TrimRight(line[:i], " ")
+		if held.alone {
+			rest = strings.TrimRight(strings.TrimSuffix(rest, "#"), " ")
+		}
+		result = append(result, rest+line[j:])
+		pad := strings.Repeat(" ", ownerColumn(line, held.item)+indent)
+		for _, c := range strings.Split(held.text, "\n") {
+			result = append(result, pad+c)
+		}
+	}
+	return []byte(strings.Join(result, "\n"))
+}
+
+// commentPlace is where a comment is written: how, and next to the node at
+// path in document doc.
+type commentPlace struct {
+	kind   commentKind
+	path   string
+	doc    int
+	before bool // a frame comment above the document's content
+	resume bool // an "on" directive, or above one: it stays at the end of its region
+}
+
+// place returns where line i of b is written. paths holds the path of b's
+// node, which need not be the one it had in the input.
+func (s *commentSet) place(b commentBinding, i int, paths map[*yaml.Node][]pathSegment) commentPlace {
+	if i < resumeLines(b.texts) {
+		return commentPlace{resume: true, doc: b.doc}
+	}
+	p := commentPlace{kind: b.kind, path: formatPath(paths[b.node]), doc: b.doc}
+	if b.kind == commentFrame {
+		p.path, p.before = "", b.lines[i] < s.roots[b.doc]
+	}
+	return p
+}
+
+// resumeLines returns how many of texts, from the first, are an "on"
+// directive or above one. moveResumeDirective keeps them at the end of the
+// region rather than with their node.
+func resumeLines(texts []string) int {
+	n := 0
+	for i, t := range texts {
+		if d, ok := parseDirective(t); ok && d.name == directiveOn {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// describe names the place for the trace and for errors. docs says whether
+// there is more than one document.
+func (p commentPlace) describe(docs bool) string {
+	where := p.path
+	if where == "" {
+		where = "the document"
+	}
+	switch {
+	case p.resume:
+		where = "end of a ysort: off region"
+	case p.kind == commentHead:
+		where = "above " + where
+	case p.kind == commentStart:
+		where = "top of " + where
+	case p.kind == commentEnd:
+		where = "end of " + where
+	case p.kind == commentBelow:
+		where = "below " + where
+	case p.kind == commentInline:
+		where = "after " + where
+	case p.kind == commentFlow:
+		where = "inside " + where
+	case p.kind == commentDocument:
+		where = "top of the document"
+	case p.kind == commentFrame && p.before:
+		where = "before the document"
+	case p.kind == commentFrame:
+		where = "after the document"
+	}
+	if docs {
+		where += fmt.Sprintf(" (document %d)", p.doc+1)
+	}
+	return where
+}
+
+// trace writes one line per comment in the input: its line number, its text
+// and the node it was bound to. docs are the sorted documents; a comment whose
+// node is no longer in them went with a dropped duplicate key.
+func (s *commentSet) trace(w io.Writer, docs []*yaml.Node) {
+	kept := reachable(docs)
+	for _, b := range s.bindings {
+		for i, l := range b.lines {
+			where := s.place(b, i, s.paths).describe(len(s.roots) > 1)
+			if !kept[b.node] {
+				where += " (dropped with its duplicate key)"
+			}
+			fmt.Fprintf(w, "%d: %s -> %s\n", l+1, b.texts[i], where)
+		}
+	}
+}
+
+// placedComment is a comment's text and where it is written.
+type placedComment struct {
+	text  string
+	place commentPlace
+}
+
+// verify checks that out, the sorted stream, has every comment of the input
+// exactly once, except those that went with a dropped duplicate key, and that
+// reading out back binds each comment to the same place: the same kind of
+// position next to the node that carries it in docs, the sorted documents.
+func (s *commentSet) verify(out []byte, docs []*yaml.Node) error {
+	want := make(map[string]int)
+	for _, c := range s.input {
+		want[c.text]++
+	}
+	kept := reachable(docs)
+	for _, b := range s.bindings {
+		if !kept[b.node] {
+			for _, t := range b.texts {
+				want[t]--
+			}
+		}
+	}
+
+	outSrc := strings.Split(string(out), "\n")
+	_, view := splitFrames(outSrc)
+	outDocs, err := decodeDocuments([]byte(strings.Join(view, "\n")))
+	if err != nil {
+		return fmt.Errorf("sorting produced invalid YAML: %w", err)
+	}
+	attachDocumentComments(outDocs, view, s.blank)
+	written := bindComments(outDocs, outSrc, view, s.blank)
+	got := make(map[string]int)
+	for _, c := range written.input {
+		got[c.text]++
+	}
+
+	for _, c := range s.input {
+		switch n := got[c.text]; {
+		case n < want[c.text]:
+			return fmt.Errorf("line %d: comment %q would be lost", c.line+1, c.text)
+		case n > want[c.text]:
+			return fmt.Errorf("line %d: comment %q would be written %d times", c.line+1, c.text, n)
+		}
+	}
+	for text := range got {
+		if _, ok := want[text]; !ok {
+			return fmt.Errorf("comment %q is not in the input", text)
+		}
+	}
+	return s.verifyPlaces(written, docs, kept)
+}
+
+// verifyPlaces checks that each comment bound in the input is bound to the
+// same place in written, the comments of the output.
+func (s *commentSet) verifyPlaces(written *commentSet, docs []*yaml.Node, kept map[*yaml.Node]bool) error {
+	have := make(map[placedComment]int)
+	for _, b := range written.bindings {
+		for i, t := range b.texts {
+			have[placedComment{t, written.place(b, i, written.paths)}]++
+		}
+	}
+	paths := nodePaths(docs)
+	var moved []sourceComment
+	var from []commentPlace
+	for _, b := range s.bindings {
+		if !kept[b.node] {
+			continue
+		}
+		for i, t := range b.texts {
+			pc := placedComment{t, s.place(b, i, paths)}
+			if have[pc] > 0 {
+				have[pc]--
+				continue
+			}
+			moved = append(moved, sourceComment{line: b.lines[i], text: t})
+			from = append(from, pc.place)
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	c, docsN := moved[0], len(s.roots) > 1
+	for _, b := range written.bindings {
+		for i, t := range b.texts {
+			if pc := (placedComment{t, written.place(b, i, written.paths)}); t == c.text && have[pc] > 0 {
+				return fmt.Errorf("line %d: comment %q would move from %s to %s", c.line+1, c.text, from[0].describe(docsN), pc.place.describe(docsN))
+			}
+		}
+	}
+	return fmt.Errorf("line %d: comment %q would not stay %s", c.line+1, c.text, from[0].describe(docsN))
+}
+
+// reachable returns the nodes in docs.
+func reachable(docs []*yaml.Node) map[*yaml.Node]bool {
+	seen := make(map[*yaml.Node]bool)
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	for _, d := range docs {
+		walk(d)
+	}
+	return seen
+}
+
+// scanComments returns the comments in lines. body maps the lines of block
+// scalars to the column of their content; a comment line there is text of the
+// scalar unless it is indented less than the content. scalars maps the lines
+// of other scalars that span lines to where their text ends (see
+// scalarLines). A bare "#" is not counted: blank lines inside comment blocks
+// are written that way.
+func scanComments(lines []string, body, scalars map[int]int) []sourceComment {
+	var out []sourceComment
+	for i, line := range lines {
+		t := strings.TrimSpace(line)
+		text, ok := "", false
+		if end, in := scalars[i]; in {
+			if rest := line[end:]; strings.HasPrefix(strings.TrimLeft(rest, " \t"), "#") && rest[0] != '#' {
+				text, ok = strings.TrimSpace(rest), true
+			}
+		} else if strings.HasPrefix(t, "#") {
+			if indent, in := body[i]; in && leadingSpaces(line) >= indent {
+				continue
+			}
+			text, ok = t, true
+		} else if _, in := body[i]; !in {
+			text, ok = lineComment(line)
+		}
+		if ok && text != "#" {
+			out = append(out, sourceComment{line: i, text: text})
+		}
+	}
+	return out
+}
+
+// lineComment returns the comment at the end of a line: the text from a "#"
+// that follows a space or tab and is not inside quotes.
+func lineComment(line string) (string, bool) {
+	prev := byte(0) // last character outside quotes that is not a space
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case (c == '\'' || c == '"') && strings.IndexByte("\x00-:?[{,", prev) >= 0:
+			i, _ = quoteEnd(line, i+1, c)
+		case c == '#' && i > 0 && (line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimSpace(line[i:]), true
+		}
+		if c != ' ' && c != '\t' {
+			prev = c
+		}
+	}
+	return "", false
+}
+
+// quoteEnd returns the index of the quote that ends a scalar quoted with
+// quote, searching line from i. It returns false if the scalar does not end
+// on this line.
+func quoteEnd(line string, i int, quote byte) (int, bool) {
+	for ; i < len(line); i++ {
+		switch {
+		case quote == '\'' && line[i] == '\'':
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++ // an escaped quote
+				continue
+			}
+			return i, true
+		case quote == '"' && line[i] == '\\':
+			i++
+		case quote == '"' && line[i] == '"':
+			return i, true
+		}
+	}
+	return len(line), false
+}
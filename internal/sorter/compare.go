@@ -0,0 +1,339 @@
+package sorter
+
+import (
+	"math/big"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Comparator selects how a list sort rule compares the values it sorts by.
+//
+// Every comparator other than ComparatorString parses the values first. Values
+// it cannot parse sort after all parsed ones and are ordered among themselves
+// as plain strings. Values that parse to the same thing (e.g. "1" and "1.0"
+// with ComparatorNumeric) are ordered by their text, so the result never
+// depends on the input order.
+type Comparator int
+
+const (
+	// ComparatorString compares values byte by byte (the default).
+	ComparatorString Comparator = iota
+	// ComparatorNumeric compares integers and decimals by value: 80 < 443 < 8080.
+	ComparatorNumeric
+	// ComparatorNatural compares runs of digits by value and everything else
+	// as text: item2 < item10.
+	ComparatorNatural
+	// ComparatorSemver compares semantic versions, with an optional "v" prefix:
+	// 1.2.0-rc.1 < 1.2.0 < 1.10.0.
+	ComparatorSemver
+	// ComparatorIP compares IP addresses and CIDR prefixes numerically, IPv4
+	// before IPv6; a prefix sorts by its address, then by its length.
+	ComparatorIP
+	// ComparatorK8sVersion compares Kubernetes API versions by priority:
+	// v1alpha1 < v1beta1 < v1beta2 < v1 < v2.
+	ComparatorK8sVersion
+	// ComparatorQuantity compares Kubernetes resource quantities by value:
+	// 500m < 1 < 1500m < 1Ki < 1Mi < 1G.
+	ComparatorQuantity
+)
+
+// ParseComparator converts a config value (e.g. "numeric") to a Comparator.
+func ParseComparator(s string) (Comparator, bool) {
+	switch s {
+	case "", "string":
+		return ComparatorString, true
+	case "numeric":
+		return ComparatorNumeric, true
+	case "natural":
+		return ComparatorNatural, true
+	case "semver":
+		return ComparatorSemver, true
+	case "ip", "cidr":
+		return ComparatorIP, true
+	case "k8s-version":
+		return ComparatorK8sVersion, true
+	case "quantity":
+		return ComparatorQuantity, true
+	}
+	return ComparatorString, false
+}
+
+// compare returns -1, 0 or +1 as a sorts before, equal to, or after b.
+func (c Comparator) compare(a, b string) int {
+	if a == b {
+		return 0
+	}
+	var n int
+	switch c {
+	case ComparatorNumeric:
+		n = compareParsed(a, b, parseNumber, func(x, y *big.Rat) int { return x.Cmp(y) })
+	case ComparatorNatural:
+		n = compareNatural(a, b)
+	case ComparatorSemver:
+		n = compareParsed(a, b, parseSemver, semver.compare)
+	case ComparatorIP:
+		n = compareParsed(a, b, parsePrefix, comparePrefix)
+	case ComparatorK8sVersion:
+		n = compareParsed(a, b, parseK8sVersion, k8sVersion.compare)
+	case ComparatorQuantity:
+		n = compareParsed(a, b, parseQuantity, func(x, y *big.Rat) int { return x.Cmp(y) })
+	}
+	if n != 0 {
+		return n
+	}
+	return strings.Compare(a, b)
+}
+
+// parses reports whether c can read s. Values it cannot read sort after the
+// others, in descending order too.
+func (c Comparator) parses(s string) bool {
+	ok := true
+	switch c {
+	case ComparatorNumeric:
+		_, ok = parseNumber(s)
+	case ComparatorSemver:
+		_, ok = parseSemver(s)
+	case ComparatorIP:
+		_, ok = parsePrefix(s)
+	case ComparatorK8sVersion:
+		_, ok = parseK8sVersion(s)
+	case ComparatorQuantity:
+		_, ok = parseQuantity(s)
+	}
+	return ok
+}
+
+// compareParsed parses both values and compares them with cmp; values that
+// don't parse sort last.
+func compareParsed[T any](a, b string, parse func(string) (T, bool), cmp func(T, T) int) int {
+	x, okA := parse(a)
+	y, okB := parse(b)
+	switch {
+	case okA && okB:
+		return cmp(x, y)
+	case okA:
+		return -1
+	case okB:
+		return 1
+	}
+	return 0
+}
+
+func parseNumber(s string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, "/xXpP_") {
+		// Rat.SetString also accepts fractions, hex and underscores; YAML numbers don't look like that.
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// compareNatural compares a and b chunk by chunk, where a chunk is either a
+// run of digits (compared by value) or a run of anything else (compared as text).
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		ca, restA := naturalChunk(a)
+		cb, restB := naturalChunk(b)
+		if isDigit(ca[0]) && isDigit(cb[0]) {
+			na, nb := strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+			if len(na) != len(nb) {
+				return compareInt(len(na), len(nb))
+			}
+			if n := strings.Compare(na, nb); n != 0 {
+				return n
+			}
+		} else if n := strings.Compare(ca, cb); n != 0 {
+			return n
+		}
+		a, b = restA, restB
+	}
+	return compareInt(len(a), len(b))
+}
+
+func naturalChunk(s string) (chunk, rest string) {
+	digit := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// semver is a parsed semantic version; build metadata is dropped since it
+// doesn't affect precedence.
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+var semverPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver accepts "1.2.3", "v1.2.3-rc.1+build" and the shorthands "1" and "1.2".
+func parseSemver(s string) (semver, bool) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+	var v semver
+	for i := range v.core {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return semver{}, false
+		}
+		v.core[i] = n
+	}
+	if m[4] != "" {
+		v.prerelease = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// compare follows semver precedence: a release sorts after its pre-releases,
+// and pre-release identifiers compare numerically when both are numbers.
+func (v semver) compare(w semver) int {
+	for i := range v.core {
+		if n := compareInt(v.core[i], w.core[i]); n != 0 {
+			return n
+		}
+	}
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		a, b := v.prerelease[i], w.prerelease[i]
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		var n int
+		switch {
+		case errA == nil && errB == nil:
+			n = compareInt(na, nb)
+		case errA == nil:
+			n = -1 // numeric identifiers have lower precedence
+		case errB == nil:
+			n = 1
+		default:
+			n = strings.Compare(a, b)
+		}
+		if n != 0 {
+			return n
+		}
+	}
+	return compareInt(len(v.prerelease), len(w.prerelease))
+}
+
+// parsePrefix accepts an IP address or a CIDR prefix; an address is treated
+// as a prefix of its full length.
+func parsePrefix(s string) (netip.Prefix, bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p, true
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(a, a.BitLen()), true
+}
+
+func comparePrefix(p, q netip.Prefix) int {
+	if n := p.Addr().Compare(q.Addr()); n != 0 {
+		return n
+	}
+	return compareInt(p.Bits(), q.Bits())
+}
+
+// k8sVersion is a parsed Kubernetes API version such as "v2beta1".
+type k8sVersion struct {
+	stability int // 0 alpha, 1 beta, 2 GA
+	major     int
+	minor     int
+}
+
+var k8sVersionPattern = regexp.MustCompile(`^v([1-9]\d*)(?:(alpha|beta)([1-9]\d*))?$`)
+
+func parseK8sVersion(s string) (k8sVersion, bool) {
+	m := k8sVersionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return k8sVersion{}, false
+	}
+	v := k8sVersion{stability: 2}
+	v.major, _ = strconv.Atoi(m[1])
+	switch m[2] {
+	case "alpha":
+		v.stability = 0
+	case "beta":
+		v.stability = 1
+	}
+	if m[3] != "" {
+		v.minor, _ = strconv.Atoi(m[3])
+	}
+	return v, true
+}
+
+// compare orders versions the way Kubernetes prioritizes them: GA over beta
+// over alpha, then by major and minor version.
+func (v k8sVersion) compare(w k8sVersion) int {
+	if n := compareInt(v.stability, w.stability); n != 0 {
+		return n
+	}
+	if n := compareInt(v.major, w.major); n != 0 {
+		return n
+	}
+	return compareInt(v.minor, w.minor)
+}
+
+var quantityPattern = regexp.MustCompile(`^([+-]?(?:\d+\.?\d*|\.\d+)(?:[eE][+-]?\d+)?)(Ki|Mi|Gi|Ti|Pi|Ei|n|u|m|k|M|G|T|P|E)?$`)
+
+// quantitySuffixes maps Kubernetes quantity suffixes to their multipliers.
+var quantitySuffixes = map[string]*big.Rat{
+	"":   big.NewRat(1, 1),
+	"n":  big.NewRat(1, 1_000_000_000),
+	"u":  big.NewRat(1, 1_000_000),
+	"m":  big.NewRat(1, 1_000),
+	"k":  big.NewRat(1_000, 1),
+	"M":  big.NewRat(1_000_000, 1),
+	"G":  big.NewRat(1_000_000_000, 1),
+	"T":  big.NewRat(1_000_000_000_000, 1),
+	"P":  big.NewRat(1_000_000_000_000_000, 1),
+	"E":  big.NewRat(1_000_000_000_000_000_000, 1),
+	"Ki": big.NewRat(1<<10, 1),
+	"Mi": big.NewRat(1<<20, 1),
+	"Gi": big.NewRat(1<<30, 1),
+	"Ti": big.NewRat(1<<40, 1),
+	"Pi": big.NewRat(1<<50, 1),
+	"Ei": big.NewRat(1<<60, 1),
+}
+
+// parseQuantity parses a Kubernetes resource quantity such as "500m", "1.5Gi" or "1e3".
+func parseQuantity(s string) (*big.Rat, bool) {
+	m := quantityPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	n, ok := new(big.Rat).SetString(m[1])
+	if !ok {
+		return nil, false
+	}
+	return n.Mul(n, quantitySuffixes[m[2]]), true
+}
@@ -0,0 +1,76 @@
+package sorter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComparators(t *testing.T) {
+	tests := []struct {
+		name       string
+		comparator string
+		input      []string
+		expected   []string
+	}{
+		{
+			name:       "string",
+			comparator: "string",
+			input:      []string{"443", "80", "10"},
+			expected:   []string{"10", "443", "80"},
+		},
+		{
+			name:       "numeric",
+			comparator: "numeric",
+			input:      []string{"443", "http", "80", "10", "8.5", "-1"},
+			expected:   []string{"-1", "8.5", "10", "80", "443", "http"},
+		},
+		{
+			name:       "natural",
+			comparator: "natural",
+			input:      []string{"item10", "item2", "Item1", "item02b", "item"},
+			expected:   []string{"Item1", "item", "item2", "item02b", "item10"},
+		},
+		{
+			name:       "semver",
+			comparator: "semver",
+			input:      []string{"1.10.0", "v1.2.0", "1.2.0-rc.1", "1.2.0-alpha", "1.2.0-rc.10", "latest", "1.2.0-rc.2"},
+			expected:   []string{"1.2.0-alpha", "1.2.0-rc.1", "1.2.0-rc.2", "1.2.0-rc.10", "v1.2.0", "1.10.0", "latest"},
+		},
+		{
+			name:       "cidr",
+			comparator: "cidr",
+			input:      []string{"10.0.0.0/8", "::1", "192.168.0.0/16", "9.9.9.9", "10.0.0.0/16", "any"},
+			expected:   []string{"9.9.9.9", "10.0.0.0/8", "10.0.0.0/16", "192.168.0.0/16", "::1", "any"},
+		},
+		{
+			name:       "k8s-version",
+			comparator: "k8s-version",
+			input:      []string{"v1", "v1alpha1", "v2", "v1beta2", "v1beta1", "v2beta1", "v1.2"},
+			expected:   []string{"v1alpha1", "v1beta1", "v1beta2", "v2beta1", "v1", "v2", "v1.2"},
+		},
+		{
+			name:       "quantity",
+			comparator: "quantity",
+			input:      []string{"1Gi", "500m", "1", "1500m", "1k", "1Ki", "1e3", "lots"},
+			expected:   []string{"500m", "1", "1500m", "1e3", "1k", "1Ki", "1Gi", "lots"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := ParseComparator(tt.comparator)
+			if !ok {
+				t.Fatalf("ParseComparator(%q) failed", tt.comparator)
+			}
+			got := slices.Clone(tt.input)
+			slices.SortFunc(got, c.compare)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("sorted = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	if _, ok := ParseComparator("alphabetical"); ok {
+		t.Error("unknown comparator should be rejected")
+	}
+}
@@ -0,0 +1,214 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Directives are comments of the form "# ysort: <name>[=<arg>]" that control
+// sorting from inside a file:
+//
+//	# ysort: ignore         above a key or list item: leave its subtree as written
+//	# ysort: off            start a region whose nodes keep their order and content
+//	# ysort: on             end the region
+//	# ysort: order=a,b,c    above a key or list item: put these keys first in its mapping
+//	# ysort: ignore-file    in the file's leading comments: leave the file unchanged
+//
+// They are ordinary comments, so they stay in the output next to the node
+// they apply to.
+const directivePrefix = "ysort:"
+
+const (
+	directiveIgnore     = "ignore"
+	directiveOff        = "off"
+	directiveOn         = "on"
+	directiveOrder      = "order"
+	directiveIgnoreFile = "ignore-file"
+)
+
+type directive struct {
+	name string
+	arg  string
+}
+
+// parseDirective returns the directive in a whole-line comment such as
+// "# ysort: order=name,image".
+func parseDirective(line string) (directive, bool) {
+	text, ok := strings.CutPrefix(strings.TrimSpace(line), "#")
+	if !ok {
+		return directive{}, false
+	}
+	rest, ok := strings.CutPrefix(strings.TrimSpace(strings.TrimLeft(text, "#")), directivePrefix)
+	if !ok {
+		return directive{}, false
+	}
+	name, arg, _ := strings.Cut(strings.TrimSpace(rest), "=")
+	return directive{name: strings.TrimSpace(name), arg: strings.TrimSpace(arg)}, true
+}
+
+// orderKeys splits the argument of an order directive into key names.
+func (d directive) orderKeys() []string {
+	var keys []string
+	for _, k := range strings.Split(d.arg, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// fileDirectives is what a scan of the source found: the line ranges between
+// "off" and "on", and whether the file asks to be left alone.
+type fileDirectives struct {
+	regions    [][2]int // [first, last] source lines, inclusive
+	ignoreFile bool
+}
+
+// scanDirectives checks every directive in lines and collects the off/on
+// regions. A region left open runs to the end of the file. The text of block
+// scalars, the lines of flow collections and those of other scalars that span
+// lines in docs are not comments.
+func scanDirectives(lines []string, docs []*yaml.Node) (fileDirectives, error) {
+	var s directiveScan
+	body := newBlockScalars(lines).bodyLines(docs)
+	flow := flowLines(docs, lines)
+	scalars := scalarLines(docs, lines)
+	for i, line := range lines {
+		_, inScalar := scalars[i]
+		if indent, in := body[i]; (in && leadingSpaces(line) >= indent) || flow[i] || inScalar {
+			s.content = true
+			continue
+		}
+		d, ok := parseDirective(line)
+		if !ok {
+			s.content = s.content || isContentLine(line)
+			continue
+		}
+		if err := s.apply(d, i+1); err != nil {
+			return s.fd, err
+		}
+	}
+	if s.off != 0 {
+		s.fd.regions = append(s.fd.regions, [2]int{s.off, len(lines)})
+	}
+	return s.fd, nil
+}
+
+// directiveScan is the state of scanDirectives.
+type directiveScan struct {
+	fd      fileDirectives
+	off     int  // line of the "off" directive of the open region, 0 if none
+	content bool // YAML content was seen above
+}
+
+// apply checks directive d, found on line n, and records what it asks for.
+func (s *directiveScan) apply(d directive, n int) error {
+	switch d.name {
+	case directiveIgnore, directiveOn, directiveOff, directiveIgnoreFile:
+		if d.arg != "" {
+			return fmt.Errorf("line %d: ysort directive %q takes no argument", n, d.name)
+		}
+	}
+	switch d.name {
+	case directiveIgnore:
+	case directiveOff:
+		if s.off == 0 {
+			s.off = n
+		}
+	case directiveOn:
+		if s.off != 0 {
+			s.fd.regions = append(s.fd.regions, [2]int{s.off, n})
+			s.off = 0
+		}
+	case directiveOrder:
+		keys := d.orderKeys()
+		if len(keys) == 0 {
+			return fmt.Errorf("line %d: ysort directive order needs keys, e.g. order=name,image", n)
+		}
+		if _, err := rankKeys(keys, nil); err != nil {
+			return fmt.Errorf("line %d: ysort directive order: %w", n, err)
+		}
+	case directiveIgnoreFile:
+		if s.content {
+			return fmt.Errorf("line %d: ysort directive ignore-file must come before any content", n)
+		}
+		s.fd.ignoreFile = true
+	default:
+		return fmt.Errorf("line %d: unknown ysort directive %q", n, d.name)
+	}
+	return nil
+}
+
+// isContentLine reports whether line holds YAML content rather than a
+// comment, a stream directive or a document start marker.
+func isContentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "%") && trimmed != "---"
+}
+
+// inRegion reports whether source line falls between "off" and "on".
+func (fd fileDirectives) inRegion(line int) bool {
+	for _, r := range fd.regions {
+		if r[0] <= line && line <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeDirectives are the directives in the comment above one node.
+type nodeDirectives struct {
+	ignore bool
+	resume bool     // "on": the node ends an off region
+	order  []string // keys pinned first by "order="
+}
+
+func commentDirectives(comment string) nodeDirectives {
+	var nd nodeDirectives
+	if comment == "" {
+		return nd
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		d, ok := parseDirective(line)
+		if !ok {
+			continue
+		}
+		switch d.name {
+		case directiveIgnore:
+			nd.ignore = true
+		case directiveOn:
+			nd.resume = true
+		case directiveOrder:
+			nd.order = d.orderKeys()
+		}
+	}
+	return nd
+}
+
+// moveResumeDirective moves the "on" directive, together with the comment
+// lines above it, from the head comment of from to that of to. The node
+// carrying it may be sorted away from the end of the off region; the
+// directive stays at the region's end.
+func moveResumeDirective(from, to *yaml.Node) {
+	if from == to {
+		return
+	}
+	lines := strings.Split(from.HeadComment, "\n")
+	end := 0
+	for i, line := range lines {
+		if d, ok := parseDirective(line); ok && d.name == directiveOn {
+			end = i + 1
+		}
+	}
+	if end == 0 {
+		return
+	}
+	from.HeadComment = strings.Join(lines[end:], "\n")
+	block := strings.Join(lines[:end], "\n")
+	if to.HeadComment != "" {
+		block += "\n" + to.HeadComment
+	}
+	to.HeadComment = block
+}
@@ -0,0 +1,99 @@
+package sorter
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DedupePolicy says what to do with a mapping key that appears more than once.
+type DedupePolicy int
+
+const (
+	// DedupeOff fails the sort and reports both occurrences (the default).
+	DedupeOff DedupePolicy = iota
+	// DedupeFirst keeps the first occurrence and drops the later ones.
+	DedupeFirst
+	// DedupeLast keeps the last occurrence, the one most YAML loaders use.
+	DedupeLast
+)
+
+// ParseDedupePolicy converts a config value ("", "first", "last") to a DedupePolicy.
+func ParseDedupePolicy(s string) (DedupePolicy, bool) {
+	switch s {
+	case "":
+		return DedupeOff, true
+	case "first":
+		return DedupeFirst, true
+	case "last":
+		return DedupeLast, true
+	}
+	return DedupeOff, false
+}
+
+// checkDuplicateKeys finds mapping keys that appear more than once anywhere
+// below node. yaml.v3 accepts them when decoding into a yaml.Node. Keys are
+// equal when they have the same tag and value, so "1" and 1 differ.
+func checkDuplicateKeys(node *yaml.Node, path []pathSegment, policy DedupePolicy) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, c := range node.Content {
+			if err := checkDuplicateKeys(c, path, policy); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, c := range node.Content {
+			if err := checkDuplicateKeys(c, append(path, indexSegment(i)), policy); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if err := dedupeMapping(node, path, policy); err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := checkDuplicateKeys(node.Content[i+1], append(path, keySegment(node.Content[i].Value)), policy); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dedupeMapping reports the first duplicate key in node or, with a dedupe
+// policy, removes all but one occurrence of each key.
+func dedupeMapping(node *yaml.Node, path []pathSegment, policy DedupePolicy) error {
+	seen := make(map[string]int) // canonical key -> index of the kept pair
+	var keep []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		id := canonicalContent(key)
+		j, dup := seen[id]
+		if !dup {
+			seen[id] = len(keep)
+			keep = append(keep, key, node.Content[i+1])
+			continue
+		}
+		switch policy {
+		case DedupeOff:
+			msg := fmt.Sprintf("duplicate key %q at lines %d and %d", key.Value, keep[j].Line, key.Line)
+			if len(path) == 0 {
+				return fmt.Errorf("%s", msg)
+			}
+			return fmt.Errorf("%s: %s", formatPath(path), msg)
+		case DedupeLast:
+			// Drop the earlier pair; the later one stays where it was written.
+			keep[j], keep[j+1] = nil, nil
+			seen[id] = len(keep)
+			keep = append(keep, key, node.Content[i+1])
+		}
+	}
+	node.Content = keep[:0]
+	for _, n := range keep {
+		if n != nil {
+			node.Content = append(node.Content, n)
+		}
+	}
+	return nil
+}
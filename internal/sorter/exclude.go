@@ -0,0 +1,67 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExcludeScope says how much of an excluded node keeps its order as written.
+type ExcludeScope int
+
+const (
+	// ExcludeSubtree leaves the node and everything below it unsorted (the default).
+	ExcludeSubtree ExcludeScope = iota
+	// ExcludeNode keeps only the node's own keys or elements in place; the
+	// mappings and lists inside it are still sorted.
+	ExcludeNode
+)
+
+// ParseExcludeScope converts a config value ("subtree", "node") to an ExcludeScope.
+func ParseExcludeScope(s string) (ExcludeScope, bool) {
+	switch s {
+	case "", "subtree":
+		return ExcludeSubtree, true
+	case "node":
+		return ExcludeNode, true
+	}
+	return ExcludeSubtree, false
+}
+
+// ExcludeRule keeps the nodes selected by Path in their original order.
+type ExcludeRule struct {
+	// Path uses the same syntax as list sort paths, e.g. "paths" or
+	// "**.tasks[*]"; RootPath excludes the whole document.
+	Path  string
+	Scope ExcludeScope
+}
+
+// ParseExcludeRule parses the --exclude flag form "path" or "path:scope",
+// e.g. "spec.values:node".
+func ParseExcludeRule(s string) (ExcludeRule, error) {
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		if scope, ok := ParseExcludeScope(s[i+1:]); ok && s[i+1:] != "" {
+			return ExcludeRule{Path: s[:i], Scope: scope}, nil
+		}
+	}
+	if s == "" {
+		return ExcludeRule{}, fmt.Errorf("empty exclude path")
+	}
+	return ExcludeRule{Path: s}, nil
+}
+
+// excludeRule is an ExcludeRule with its path compiled.
+type excludeRule struct {
+	pattern pathPattern
+	scope   ExcludeScope
+}
+
+func compileExcludeRule(r ExcludeRule) (excludeRule, error) {
+	if r.Path == RootPath {
+		return excludeRule{pattern: pathPattern{source: r.Path}, scope: r.Scope}, nil
+	}
+	pattern, err := compilePathPattern(r.Path)
+	if err != nil {
+		return excludeRule{}, fmt.Errorf("invalid exclude path: %w", err)
+	}
+	return excludeRule{pattern: pattern, scope: r.Scope}, nil
+}
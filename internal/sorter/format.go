@@ -0,0 +1,244 @@
+package sorter
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultIndent is used when the input has no nested block to measure.
+const defaultIndent = 2
+
+// MinIndent and MaxIndent bound the indentation widths the encoder can write;
+// it falls back to 2 spaces outside them.
+const (
+	MinIndent = 2
+	MaxIndent = 9
+)
+
+// SequenceIndent controls how a block sequence that is the value of a mapping
+// key is indented relative to that key.
+type SequenceIndent int
+
+const (
+	// SequenceIndentAuto reuses the style found in the input (indented when none is found).
+	SequenceIndentAuto SequenceIndent = iota
+	// SequenceIndentIndented writes "key:\n  - item".
+	SequenceIndentIndented
+	// SequenceIndentIndentless writes "key:\n- item".
+	SequenceIndentIndentless
+)
+
+// ParseSequenceIndent converts a flag value ("auto", "indented", "indentless")
+// to a SequenceIndent.
+func ParseSequenceIndent(s string) (SequenceIndent, bool) {
+	switch s {
+	case "", "auto":
+		return SequenceIndentAuto, true
+	case "indented":
+		return SequenceIndentIndented, true
+	case "indentless":
+		return SequenceIndentIndentless, true
+	}
+	return SequenceIndentAuto, false
+}
+
+// layout is the output indentation resolved from Options and the input.
+type layout struct {
+	indent     int
+	indentless bool
+}
+
+// resolveLayout fills in the indentation settings left on auto in opts by
+// measuring the parsed documents.
+func resolveLayout(docs []*yaml.Node, opts Options) layout {
+	d := indentDetector{widths: map[int]int{}}
+	for _, doc := range docs {
+		d.walk(doc)
+	}
+
+	l := layout{indent: opts.Indent}
+	if l.indent <= 0 {
+		l.indent = d.width()
+	}
+	// A 1-space input is written with 2 spaces.
+	l.indent = min(max(l.indent, MinIndent), MaxIndent)
+	switch opts.SequenceIndent {
+	case SequenceIndentIndented:
+		l.indentless = false
+	case SequenceIndentIndentless:
+		l.indentless = true
+	default:
+		l.indentless = d.indentless > d.indented
+	}
+	return l
+}
+
+// indentDetector tallies how far nested blocks are indented below their key.
+type indentDetector struct {
+	widths     map[int]int // indent width -> occurrences
+	indented   int
+	indentless int
+}
+
+func (d *indentDetector) walk(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.MappingNode && len(node.Content)%2 == 0 {
+		for i := 0; i < len(node.Content); i += 2 {
+			d.measure(node.Content[i], node.Content[i+1])
+		}
+	}
+	for _, child := range node.Content {
+		d.walk(child)
+	}
+}
+
+func (d *indentDetector) measure(key, value *yaml.Node) {
+	if value.Style&yaml.FlowStyle != 0 || len(value.Content) == 0 || value.Line <= key.Line {
+		return
+	}
+	offset := value.Column - key.Column
+	switch value.Kind {
+	case yaml.MappingNode:
+		if offset > 0 {
+			d.widths[offset]++
+		}
+	case yaml.SequenceNode:
+		if offset == 0 {
+			d.indentless++
+			return
+		}
+		d.indented++
+		d.widths[offset]++
+	}
+}
+
+// width returns the most common indent width, preferring the smaller on ties.
+func (d *indentDetector) width() int {
+	best, bestCount := defaultIndent, 0
+	for w, c := range d.widths {
+		if c > bestCount || (c == bestCount && w < best) {
+			best, bestCount = w, c
+		}
+	}
+	return best
+}
+
+var (
+	// keyOnlyLine matches a line that opens a block under a mapping key, e.g.
+	// "key:", "- key: &anchor" or "key: # comment". Group 1 is the text before
+	// the key.
+	keyOnlyLine = regexp.MustCompile(`^((?:- )*)[^#\s].*:(?:\s+[&!]\S*)*(?:\s+#.*)?$`)
+	// blockScalarLine matches a line whose value starts a literal or folded block scalar.
+	blockScalarLine = regexp.MustCompile(`(?:^|:\s|-\s)[|>][0-9+-]*(?:\s+#.*)?$`)
+)
+
+// alignNested rewrites encoder output so that a block nested under a mapping
+// key starts indent spaces deeper than the key. Inside a "- " item the
+// encoder rounds that column up to the next multiple of indent instead, which
+// is another column when indent is not 2.
+func alignNested(out []byte, indent int) []byte {
+	type level struct{ from, to int } // a block's column in out and where it goes
+	lines := strings.Split(string(out), "\n")
+	levels := []level{{0, 0}}
+	opened := -1 // where the block opened by the previous line goes, or -1
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			continue
+		}
+		col := len(line) - len(trimmed)
+		for len(levels) > 1 && levels[len(levels)-1].from > col {
+			levels = levels[:len(levels)-1]
+		}
+		top := levels[len(levels)-1]
+		to := col + top.to - top.from
+		if col > top.from {
+			if opened >= 0 {
+				to = opened
+			}
+			levels = append(levels, level{col, to})
+		}
+		opened = -1
+		lines[i] = strings.Repeat(" ", to) + trimmed
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		dashes := 0
+		for strings.HasPrefix(trimmed[dashes:], "- ") {
+			dashes += 2
+			levels = append(levels, level{col + dashes, to + dashes})
+		}
+		if keyOnlyLine.MatchString(trimmed) {
+			opened = to + dashes + indent
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// dedentSequences rewrites encoder output so that block sequences nested
+// directly under a mapping key start at the key's column ("indentless"). The
+// encoder always indents them by one level; everything inside such a sequence
+// is shifted left by indent spaces.
+func dedentSequences(out []byte, indent int) []byte {
+	lines := strings.Split(string(out), "\n")
+	var keyCols []int // columns of the keys whose sequences are being dedented
+	scalarParent := -1
+
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			continue
+		}
+		col := len(line) - len(trimmed)
+
+		if scalarParent >= 0 && col > scalarParent {
+			lines[i] = line[min(len(keyCols)*indent, col):]
+			continue
+		}
+		scalarParent = -1
+
+		isComment := strings.HasPrefix(trimmed, "#")
+		if !isComment {
+			for len(keyCols) > 0 && col <= keyCols[len(keyCols)-1] {
+				keyCols = keyCols[:len(keyCols)-1]
+			}
+		}
+		shift := 0
+		for _, k := range keyCols {
+			if k < col {
+				shift += indent
+			}
+		}
+		lines[i] = line[min(shift, col):]
+
+		if isComment {
+			continue
+		}
+		if blockScalarLine.MatchString(trimmed) {
+			scalarParent = col
+			continue
+		}
+		if m := keyOnlyLine.FindStringSubmatch(trimmed); m != nil {
+			keyCol := col + len(m[1])
+			if next := nextContentLine(lines[i+1:]); strings.HasPrefix(next, strings.Repeat(" ", keyCol+indent)+"- ") {
+				keyCols = append(keyCols, keyCol)
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// nextContentLine returns the first line that is neither blank nor a comment.
+func nextContentLine(lines []string) string {
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		if t != "" && !strings.HasPrefix(t, "#") {
+			return l
+		}
+	}
+	return ""
+}
@@ -0,0 +1,244 @@
+package sorter
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// docFrame is the text around one document that belongs to the stream rather
+// than to its root node: directives (%YAML, %TAG), the "---" and "..."
+// markers, comments before "---", and a "# yaml-language-server:" modeline at
+// the top of the document. It is copied to the output as written.
+type docFrame struct {
+	preamble []string     // lines before the document's content
+	trailer  []string     // the "..." line and whatever follows it
+	explicit bool         // preamble ends with the "---" marker
+	tags     tagShortener // from the %TAG directives
+}
+
+// modelinePrefix starts the comment that tells editors which JSON schema
+// applies to a file.
+const modelinePrefix = "yaml-language-server:"
+
+// tagDirective matches "%TAG !e! tag:example.com,2000:".
+var tagDirective = regexp.MustCompile(`^%TAG\s+(\S+)\s+(\S+)`)
+
+// splitFrames finds the frame of every document in lines. It returns the
+// lines yaml.v3 should parse instead: frame comments and directives other than
+// %TAG are blanked and markers lose their comments, so that the comments do
+// not end up on nodes and "%YAML 1.2", which yaml.v3 rejects, is not seen.
+// Line numbers stay the same.
+func splitFrames(lines []string) ([]docFrame, []string) {
+	f := frameSplitter{lines: lines, view: make([]string, len(lines)), n: len(lines), between: true}
+	copy(f.view, lines)
+	if f.n > 0 && lines[f.n-1] == "" {
+		f.n-- // the final newline
+	}
+
+	for i := 0; i < f.n; i++ {
+		line := lines[i]
+		marker, pure := documentMarker(line, "---")
+		switch {
+		case f.between && strings.HasPrefix(line, "%"):
+			f.directive(i)
+		case f.between && isBlankOrComment(line):
+			f.take(i, i+1)
+		case marker:
+			if !f.between {
+				// Comments at column 0 and blank lines right above "---"
+				// introduce the next document; they move from the previous
+				// one to this frame.
+				f.take(f.commentsAbove(i), i)
+			}
+			i = f.explicitStart(i, pure)
+		case f.between:
+			f.implicitStart(i)
+		default:
+			if end, _ := documentMarker(line, "..."); end && len(f.frames) > 0 {
+				f.end(i)
+			}
+		}
+	}
+	if f.between && len(f.frames) > 0 {
+		// Comments after the last "..." stay at the end.
+		last := &f.frames[len(f.frames)-1]
+		last.trailer = append(last.trailer, f.cur.preamble...)
+	}
+	return f.frames, f.view
+}
+
+// frameSplitter is the state of splitFrames.
+type frameSplitter struct {
+	lines       []string
+	view        []string // what yaml.v3 parses
+	n           int      // lines without the final newline
+	frames      []docFrame
+	cur         docFrame // the frame being collected
+	between     bool     // not inside a document's content
+	regionStart int      // first line after the last marker
+}
+
+// take moves lines from..to-1 into the current frame.
+func (f *frameSplitter) take(from, to int) {
+	for k := from; k < to; k++ {
+		f.cur.preamble = append(f.cur.preamble, f.lines[k])
+		f.view[k] = ""
+	}
+}
+
+// commentsAbove returns the first line of the comments at column 0 and blank
+// lines right above line i. An indented comment ends a block of the document
+// and stops the search; bindComments places it.
+func (f *frameSplitter) commentsAbove(i int) int {
+	j := i
+	for j > f.regionStart && (strings.TrimSpace(f.lines[j-1]) == "" || strings.HasPrefix(f.lines[j-1], "#")) {
+		j--
+	}
+	return j
+}
+
+// directive takes the directive on line i into the frame. yaml.v3 still
+// parses a %TAG, and its handle is remembered for the output.
+func (f *frameSplitter) directive(i int) {
+	f.take(i, i+1)
+	if m := tagDirective.FindStringSubmatch(f.lines[i]); m != nil {
+		f.cur.tags.add(m[2], m[1])
+		f.view[i] = f.lines[i]
+	}
+}
+
+// explicitStart closes the frame at the "---" on line i and returns the last
+// line it took.
+func (f *frameSplitter) explicitStart(i int, pure bool) int {
+	f.cur.explicit = true
+	if !pure {
+		// Content on the marker line ("--- |") is yaml.v3's to parse; the
+		// encoder writes it below a bare "---".
+		f.cur.preamble = append(f.cur.preamble, "---")
+		f.startDocument(i + 1)
+		return i
+	}
+	f.cur.preamble = append(f.cur.preamble, f.lines[i])
+	f.view[i] = "---"
+	if i+1 < f.n && isModeline(f.lines[i+1]) {
+		// The modeline and the blank lines after it stay on top.
+		end := i + 2
+		for end < f.n && strings.TrimSpace(f.lines[end]) == "" {
+			end++
+		}
+		f.take(i+1, end)
+		i = end - 1
+	}
+	if end, ok := emptyDocument(f.lines, i+1, f.n); ok {
+		// Nothing but comments, like a Helm "# Source:" line for a template
+		// that rendered empty: all of it is frame.
+		f.take(i+1, end)
+		i = end - 1
+	}
+	f.startDocument(i + 1)
+	return i
+}
+
+// implicitStart closes the frame of a document without "---" whose content
+// starts on line i. The comments above the content are its own, except for a
+// modeline on the region's first line and the blank lines after it.
+func (f *frameSplitter) implicitStart(i int) {
+	keep := 0
+	if len(f.cur.preamble) > 0 && isModeline(f.cur.preamble[0]) {
+		keep = 1
+		for keep < len(f.cur.preamble) && strings.TrimSpace(f.cur.preamble[keep]) == "" {
+			keep++
+		}
+	}
+	for k := f.regionStart + keep; k < i; k++ {
+		f.view[k] = f.lines[k]
+	}
+	f.cur.preamble = f.cur.preamble[:keep]
+	f.frames = append(f.frames, f.cur)
+	f.cur, f.between = docFrame{}, false
+}
+
+// startDocument closes the current frame; the document's content starts on
+// line from.
+func (f *frameSplitter) startDocument(from int) {
+	f.frames = append(f.frames, f.cur)
+	f.cur, f.between = docFrame{}, false
+	f.regionStart = from
+}
+
+// end moves the "..." on line i, and like "---" the comments at column 0
+// right above it, into the trailer of the last frame.
+func (f *frameSplitter) end(i int) {
+	last := &f.frames[len(f.frames)-1]
+	for k := f.commentsAbove(i); k <= i; k++ {
+		last.trailer = append(last.trailer, f.lines[k])
+		f.view[k] = ""
+	}
+	f.view[i] = "..."
+	f.between = true
+	f.regionStart = i + 1
+}
+
+// emptyDocument reports whether the document whose content would start at
+// line from has only blank lines and comments, and returns the line of the
+// marker that follows it, or n.
+func emptyDocument(lines []string, from, n int) (int, bool) {
+	end := from
+	for end < n && isBlankOrComment(lines[end]) {
+		end++
+	}
+	if end == n {
+		return end, true
+	}
+	next, _ := documentMarker(lines[end], "---")
+	last, _ := documentMarker(lines[end], "...")
+	return end, next || last
+}
+
+// documentMarker reports whether line starts with marker ("---" or "...") as
+// a document marker, and whether nothing but a comment follows it.
+func documentMarker(line, marker string) (ok, pure bool) {
+	rest, found := strings.CutPrefix(line, marker)
+	if !found || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return false, false
+	}
+	rest = strings.TrimSpace(rest)
+	return true, rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// isModeline reports whether line is a "# yaml-language-server: ..." comment.
+func isModeline(line string) bool {
+	text, ok := strings.CutPrefix(strings.TrimSpace(line), "#")
+	return ok && strings.HasPrefix(strings.TrimSpace(text), modelinePrefix)
+}
+
+// writeLines writes lines to buf, each followed by a line break.
+func writeLines(buf *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+}
+
+// tagShortener writes tags that yaml.v3 expanded to "!<prefix...>" with the
+// handle their document's %TAG directive declared.
+type tagShortener struct {
+	patterns []*regexp.Regexp
+	handles  []string // replacement templates, "$" escaped
+}
+
+// add compiles the pattern for the tag prefix that a %TAG directive gives
+// handle.
+func (t *tagShortener) add(prefix, handle string) {
+	t.patterns = append(t.patterns, regexp.MustCompile(`!<`+regexp.QuoteMeta(prefix)+`([^>\s]*)>`))
+	t.handles = append(t.handles, strings.ReplaceAll(handle, "$", "$$")+"${1}")
+}
+
+// shorten rewrites the expanded tags in out.
+func (t tagShortener) shorten(out string) string {
+	for i, re := range t.patterns {
+		out = re.ReplaceAllString(out, t.handles[i])
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package sorter
+
+import "fmt"
+
+// RootPath selects the document's root mapping in a KeyOrderRule.
+const RootPath = "."
+
+// KeyOrderRule pins the order of some keys in the mappings selected by Path.
+// Keys in First come first and keys in Last come last, each in the order
+// listed; all other keys are sorted alphabetically between them.
+type KeyOrderRule struct {
+	// Path selects mappings with the same syntax as list sort paths, e.g.
+	// "spec.template.spec.containers[*]", or RootPath for the root mapping.
+	Path  string
+	First []string
+	Last  []string
+}
+
+// k8sRootKeyOrder is the built-in rule behind Options.K8sRoot.
+func k8sRootKeyOrder() KeyOrderRule {
+	return KeyOrderRule{Path: RootPath, First: K8sRootKeyOrder}
+}
+
+// keyOrderRule is a KeyOrderRule with its path compiled.
+type keyOrderRule struct {
+	pattern pathPattern
+	rank    map[string]int // negative for First keys, positive for Last keys
+}
+
+func compileKeyOrderRule(r KeyOrderRule) (keyOrderRule, error) {
+	var rule keyOrderRule
+	if r.Path == RootPath {
+		rule.pattern = pathPattern{source: r.Path}
+	} else {
+		pattern, err := compilePathPattern(r.Path)
+		if err != nil {
+			return rule, fmt.Errorf("invalid key order path: %w", err)
+		}
+		rule.pattern = pattern
+	}
+	rank, err := rankKeys(r.First, r.Last)
+	if err != nil {
+		return rule, fmt.Errorf("key order rule %q: %w", r.Path, err)
+	}
+	rule.rank = rank
+	return rule, nil
+}
+
+// rankKeys gives First keys negative and Last keys positive ranks, so that
+// unlisted keys (rank 0) fall between them.
+func rankKeys(first, last []string) (map[string]int, error) {
+	rank := make(map[string]int, len(first)+len(last))
+	for i, k := range first {
+		if _, dup := rank[k]; dup {
+			return nil, fmt.Errorf("key %q listed twice", k)
+		}
+		rank[k] = i - len(first)
+	}
+	for i, k := range last {
+		if _, dup := rank[k]; dup {
+			return nil, fmt.Errorf("key %q listed twice", k)
+		}
+		rank[k] = i + 1
+	}
+	return rank, nil
+}
+
+// less orders pinned First keys, then all unlisted keys alphabetically, then
+// pinned Last keys.
+func (r keyOrderRule) less(a, b string) bool {
+	ra, rb := r.rank[a], r.rank[b]
+	if ra != rb {
+		return ra < rb
+	}
+	return a < b
+}
@@ -0,0 +1,77 @@
+package sorter
+
+import (
+	"bytes"
+	"slices"
+)
+
+// LineEndings selects the line breaks of the output.
+type LineEndings int
+
+const (
+	// LineEndingsAuto keeps the line breaks of the input: CRLF when most of
+	// its lines end in "\r\n", LF otherwise.
+	LineEndingsAuto LineEndings = iota
+	// LineEndingsLF writes "\n".
+	LineEndingsLF
+	// LineEndingsCRLF writes "\r\n".
+	LineEndingsCRLF
+)
+
+// ParseLineEndings converts a flag value ("auto", "lf", "crlf") to LineEndings.
+func ParseLineEndings(s string) (LineEndings, bool) {
+	switch s {
+	case "", "auto":
+		return LineEndingsAuto, true
+	case "lf":
+		return LineEndingsLF, true
+	case "crlf":
+		return LineEndingsCRLF, true
+	}
+	return LineEndingsAuto, false
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// textFormat is what the input looked like around the YAML itself.
+type textFormat struct {
+	bom          bool // starts with a UTF-8 byte order mark
+	crlf         bool // most line breaks are "\r\n"
+	finalNewline bool // ends with a line break
+}
+
+// detectFormat strips the byte order mark and CRLF line breaks from data, so
+// that the rest of the sorter only sees "\n", and reports what it found.
+func detectFormat(data []byte) ([]byte, textFormat) {
+	var f textFormat
+	if rest, ok := bytes.CutPrefix(data, utf8BOM); ok {
+		f.bom = true
+		data = rest
+	}
+	crlf := bytes.Count(data, []byte("\r\n"))
+	f.crlf = crlf > bytes.Count(data, []byte("\n"))-crlf
+	f.finalNewline = bytes.HasSuffix(data, []byte("\n"))
+	if crlf > 0 {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+	return data, f
+}
+
+// restore gives out, written with "\n" line breaks, the input's format back.
+// endings overrides the line breaks found in the input.
+func (f textFormat) restore(out []byte, endings LineEndings) []byte {
+	if f.finalNewline {
+		if !bytes.HasSuffix(out, []byte("\n")) {
+			out = append(out, '\n')
+		}
+	} else {
+		out = bytes.TrimRight(out, "\n")
+	}
+	if endings == LineEndingsCRLF || (endings == LineEndingsAuto && f.crlf) {
+		out = bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n"))
+	}
+	if f.bom {
+		out = append(slices.Clone(utf8BOM), out...)
+	}
+	return out
+}
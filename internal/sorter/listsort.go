@@ -0,0 +1,278 @@
+package sorter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ListSortRule sorts the lists selected by Path by values found in each element.
+type ListSortRule struct {
+	// Path selects the lists to sort; see Options.ListSortKeys for the syntax.
+	Path string
+	// By chooses what to compare: a key inside each element, or for lists of
+	// scalars the element's own value.
+	By SortBy
+	// Keys are compared in order, each a dot-separated path inside the element
+	// (e.g. "metadata.name"); later keys break ties between earlier ones.
+	Keys []string
+	// Compare chooses how values are compared (string by default).
+	Compare Comparator
+	// TieBreak orders elements whose keys compare equal. By default they keep
+	// their source order.
+	TieBreak TieBreak
+	// Order is ascending by default; OrderDesc reverses the comparison.
+	Order Order
+	// Missing places elements that lack a key before or after those that have
+	// it, or rejects them. It does not depend on Order.
+	Missing MissingPosition
+}
+
+// Order is the direction of a list sort.
+type Order int
+
+const (
+	// OrderAsc sorts smallest first (the default).
+	OrderAsc Order = iota
+	// OrderDesc sorts largest first, e.g. newest version first.
+	OrderDesc
+)
+
+// ParseOrder converts a config value ("asc", "desc") to an Order.
+func ParseOrder(s string) (Order, bool) {
+	switch s {
+	case "", "asc":
+		return OrderAsc, true
+	case "desc":
+		return OrderDesc, true
+	}
+	return OrderAsc, false
+}
+
+// SortBy says what a list sort rule compares.
+type SortBy int
+
+const (
+	// SortByKey compares the values of Keys inside each element (the default).
+	SortByKey SortBy = iota
+	// SortByValue compares scalar elements by their own value.
+	SortByValue
+)
+
+// ParseSortBy converts a config value ("key", "value") to a SortBy.
+func ParseSortBy(s string) (SortBy, bool) {
+	switch s {
+	case "", "key":
+		return SortByKey, true
+	case "value":
+		return SortByValue, true
+	}
+	return SortByKey, false
+}
+
+// TieBreak says how a list sort orders elements whose keys compare equal.
+type TieBreak int
+
+const (
+	// TieBreakNone keeps equal elements in source order (the default).
+	TieBreakNone TieBreak = iota
+	// TieBreakContent orders equal elements by a canonical serialization of
+	// the whole element, ignoring comments, styles and mapping key order, so
+	// the same data always produces the same output.
+	TieBreakContent
+)
+
+// ParseTieBreak converts a config value ("none", "content") to a TieBreak.
+func ParseTieBreak(s string) (TieBreak, bool) {
+	switch s {
+	case "", "none":
+		return TieBreakNone, true
+	case "content":
+		return TieBreakContent, true
+	}
+	return TieBreakNone, false
+}
+
+// MissingPosition says where list elements without a sort key go.
+type MissingPosition int
+
+const (
+	// MissingFirst sorts elements without the key before all others (the default).
+	MissingFirst MissingPosition = iota
+	// MissingLast sorts elements without the key after all others.
+	MissingLast
+	// MissingError fails the sort when an element lacks a key.
+	MissingError
+)
+
+// ParseMissingPosition converts a config value ("first", "last", "error") to a MissingPosition.
+func ParseMissingPosition(s string) (MissingPosition, bool) {
+	switch s {
+	case "", "first":
+		return MissingFirst, true
+	case "last":
+		return MissingLast, true
+	case "error":
+		return MissingError, true
+	}
+	return MissingFirst, false
+}
+
+// listRule is a ListSortRule with its path compiled.
+type listRule struct {
+	pattern  pathPattern
+	keys     [][]string // each key split into its dotted segments; one empty key for SortByValue
+	compare  Comparator
+	order    Order
+	missing  MissingPosition
+	tieBreak TieBreak
+}
+
+func compileListRule(r ListSortRule) (listRule, error) {
+	pattern, err := compilePathPattern(r.Path)
+	if err != nil {
+		return listRule{}, fmt.Errorf("invalid list sort path: %w", err)
+	}
+	rule := listRule{pattern: pattern, compare: r.Compare, order: r.Order, missing: r.Missing, tieBreak: r.TieBreak}
+	if r.By == SortByValue {
+		if len(r.Keys) > 0 {
+			return listRule{}, fmt.Errorf("list sort rule %q: keys cannot be used when sorting by value", r.Path)
+		}
+		// An empty key path makes lookupScalar return the element itself.
+		rule.keys = [][]string{nil}
+		return rule, nil
+	}
+	if len(r.Keys) == 0 {
+		return listRule{}, fmt.Errorf("list sort rule %q: no key to sort by", r.Path)
+	}
+	for _, k := range r.Keys {
+		if strings.TrimSpace(k) == "" {
+			return listRule{}, fmt.Errorf("list sort rule %q: empty key", r.Path)
+		}
+		rule.keys = append(rule.keys, strings.Split(k, "."))
+	}
+	return rule, nil
+}
+
+// sortValue is the value of one sort key in a list element.
+type sortValue struct {
+	value   string
+	present bool
+}
+
+// sortKey is everything a rule compares about one list element.
+type sortKey struct {
+	values  []sortValue
+	content string // canonical form of the element, for TieBreakContent
+}
+
+// sortKey extracts every key of the rule from element. It fails if a key is
+// missing and the rule's Missing is MissingError.
+func (r listRule) sortKey(element *yaml.Node) (sortKey, error) {
+	k := sortKey{values: make([]sortValue, len(r.keys))}
+	for i, key := range r.keys {
+		k.values[i].value, k.values[i].present = lookupScalar(element, key)
+		if !k.values[i].present && r.missing == MissingError {
+			if len(key) == 0 {
+				return k, fmt.Errorf("element is not a scalar value")
+			}
+			return k, fmt.Errorf("element has no %q to sort by", strings.Join(key, "."))
+		}
+	}
+	if r.tieBreak == TieBreakContent {
+		k.content = canonicalContent(element)
+	}
+	return k, nil
+}
+
+// less compares two elements' sort keys value by value, then by content when
+// the rule breaks ties that way.
+func (r listRule) less(a, b sortKey) bool {
+	for i := range a.values {
+		va, vb := a.values[i], b.values[i]
+		if va.present != vb.present {
+			// Exactly one element lacks this key.
+			return va.present == (r.missing == MissingLast)
+		}
+		pa, pb := r.compare.parses(va.value), r.compare.parses(vb.value)
+		if pa != pb {
+			return pa
+		}
+		n := r.compare.compare(va.value, vb.value)
+		if r.order == OrderDesc && pa {
+			// Unparsed values stay in text order.
+			n = -n
+		}
+		if n != 0 {
+			return n < 0
+		}
+	}
+	return a.content < b.content
+}
+
+// canonicalContent serializes node so that equal data gives equal strings:
+// comments, quoting and flow/block style are dropped, mapping entries are
+// ordered by key, and aliases are replaced by what they point to.
+func canonicalContent(node *yaml.Node) string {
+	var sb strings.Builder
+	writeCanonical(&sb, node)
+	return sb.String()
+}
+
+func writeCanonical(sb *strings.Builder, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, c := range node.Content {
+			writeCanonical(sb, c)
+		}
+	case yaml.AliasNode:
+		writeCanonical(sb, node.Alias)
+	case yaml.ScalarNode:
+		fmt.Fprintf(sb, "%s%q", node.ShortTag(), node.Value)
+	case yaml.SequenceNode:
+		sb.WriteByte('[')
+		for i, c := range node.Content {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeCanonical(sb, c)
+		}
+		sb.WriteByte(']')
+	case yaml.MappingNode:
+		entries := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			entries = append(entries, canonicalContent(node.Content[i])+":"+canonicalContent(node.Content[i+1]))
+		}
+		sort.Strings(entries)
+		sb.WriteByte('{')
+		sb.WriteString(strings.Join(entries, ","))
+		sb.WriteByte('}')
+	}
+}
+
+// lookupScalar follows the dotted key segments through nested mappings and
+// returns the scalar found there. Keys that themselves contain dots (e.g.
+// "app.kubernetes.io/name") are matched by trying the longest run of segments
+// first. Missing keys, null values and non-scalar values report false.
+func lookupScalar(node *yaml.Node, segments []string) (string, bool) {
+	if len(segments) == 0 {
+		if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+			return "", false
+		}
+		return node.Value, true
+	}
+	if node.Kind != yaml.MappingNode {
+		return "", false
+	}
+	for n := len(segments); n > 0; n-- {
+		key := strings.Join(segments[:n], ".")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return lookupScalar(node.Content[i+1], segments[n:])
+			}
+		}
+	}
+	return "", false
+}
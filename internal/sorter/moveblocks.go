@@ -0,0 +1,464 @@
+package sorter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// With Options.MoveBlocks the sorted tree is not encoded. The source is cut
+// into one block of lines per mapping entry or list item, together with the
+// comments directly above it, and the blocks are put back in sorted order.
+// Whatever did not move stays byte for byte as written: quoting, indentation,
+// comments, and blank lines, which stay where they were between the blocks.
+// Only flow collections whose order changed are encoded again.
+
+// sourceOrder remembers the children of every collection as decoded, before
+// duplicate keys are dropped and the tree is sorted.
+type sourceOrder map[*yaml.Node][]*yaml.Node
+
+// record remembers the children of the collections in and below nodes.
+func (order sourceOrder) record(nodes ...*yaml.Node) {
+	for _, node := range nodes {
+		if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+			order[node] = slices.Clone(node.Content)
+		}
+		order.record(node.Content...)
+	}
+}
+
+// block is the source lines start..end (0-based, inclusive) of one mapping
+// entry or list item. line and col locate its key or "-".
+type block struct {
+	start, line, end int
+	col              int
+	node             *yaml.Node // the key, or the list item
+	value            *yaml.Node // the value, or the list item
+}
+
+type blockWriter struct {
+	src    []string // the source split on "\n", as written
+	scan   []string // src with document comment fences, see attachDocumentComments
+	order  sourceOrder
+	splice map[int]string // re-encoded flow collections: first line -> new text
+	drop   map[int]bool   // further lines of re-encoded flow collections
+}
+
+// moveBlocks writes the sorted docs by moving blocks of src around.
+func moveBlocks(docs []*yaml.Node, src, scan []string, order sourceOrder) ([]byte, error) {
+	w := &blockWriter{src: src, scan: scan, order: order, splice: make(map[int]string), drop: make(map[int]bool)}
+	for _, doc := range docs {
+		if err := w.spliceFlows(doc.Content[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	starts := w.documentStarts(docs)
+	var out []string
+	for i, doc := range docs {
+		to := len(src) - 1
+		if i+1 < len(docs) {
+			to = starts[i+1] - 1
+		}
+		lines, _, err := w.render(doc.Content[0], starts[i], to, -1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, lines...)
+	}
+
+	_, view := splitFrames(out)
+	if _, err := decodeDocuments([]byte(strings.Join(view, "\n"))); err != nil {
+		return nil, fmt.Errorf("moving blocks produced invalid YAML: %w", err)
+	}
+	return []byte(strings.Join(out, "\n")), nil
+}
+
+// documentStarts returns the first source line of each document: 0 for the
+// first, else the "---" line above its root node.
+func (w *blockWriter) documentStarts(docs []*yaml.Node) []int {
+	starts := make([]int, len(docs))
+	for i := 1; i < len(docs); i++ {
+		starts[i] = docs[i].Content[0].Line - 1
+		for j := starts[i]; j > starts[i-1]; j-- {
+			if strings.HasPrefix(w.src[j], "---") && isDocumentMarker(w.src[j][:3]) {
+				starts[i] = j
+				break
+			}
+		}
+	}
+	return starts
+}
+
+// render returns source lines from..to, which hold node, with the blocks of
+// node's entries or items in sorted order, and the same done inside each
+// block. keyLine is the line of the key or "-" that node belongs to, or -1 at
+// the root. The second result is the index of the line that now holds that
+// key or "-".
+func (w *blockWriter) render(node *yaml.Node, from, to, keyLine int) ([]string, int, error) {
+	blocks, err := w.blocks(node, from, to, keyLine)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(blocks) == 0 {
+		return w.verbatim(from, to), keyLine - from, nil
+	}
+
+	// In "- name: app" the first key shares its line with the "-": the first
+	// line of whichever entry is now first gets the "- ", as in
+	// "- # comment", and the others get spaces.
+	compact := blocks[0].line == keyLine
+	byNode := make(map[*yaml.Node]block, len(blocks))
+	for _, b := range blocks {
+		byNode[b.node] = b
+	}
+	sorted := node.Content
+	if node.Kind == yaml.MappingNode {
+		sorted = nil
+		for i := 0; i < len(node.Content); i += 2 {
+			sorted = append(sorted, node.Content[i])
+		}
+	}
+
+	out := w.verbatim(from, blocks[0].start-1)
+	at := keyLine - from
+	for i, slot := range blocks {
+		if i > 0 {
+			out = append(out, w.verbatim(blocks[i-1].end+1, slot.start-1)...)
+		}
+		if i >= len(sorted) {
+			continue // a dropped duplicate
+		}
+		b := byNode[sorted[i]]
+		lines, key, err := w.render(b.value, b.start, b.end, b.line)
+		if err != nil {
+			return nil, 0, err
+		}
+		lines, key = fitHead(lines, key, b.col, i == 0)
+		if compact {
+			if b.line == keyLine {
+				lines[key] = strings.Repeat(" ", b.col) + lines[key][b.col:]
+			}
+			if i == 0 {
+				at = len(out)
+			}
+		}
+		out = append(out, lines...)
+	}
+	out = append(out, w.verbatim(blocks[len(blocks)-1].end+1, to)...)
+	if compact {
+		col := blocks[0].col
+		out[at] = w.src[keyLine][:col] + out[at][col:]
+	}
+	return out, at, nil
+}
+
+// blocks cuts lines from..to into the blocks of node's entries or items, in
+// source order. It returns nil for anything that is not a block collection.
+func (w *blockWriter) blocks(node *yaml.Node, from, to, keyLine int) ([]block, error) {
+	if node.Style&yaml.FlowStyle != 0 {
+		return nil, nil
+	}
+	children := w.order[node]
+	var blocks []block
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(children); i += 2 {
+			key := children[i]
+			blocks = append(blocks, block{line: key.Line - 1, col: key.Column - 1, node: key, value: children[i+1]})
+		}
+	case yaml.SequenceNode:
+		col := node.Column - 1
+		for _, item := range children {
+			blocks = append(blocks, block{line: dashLine(w.src, item, col), col: col, node: item, value: item})
+		}
+	}
+
+	for i := range blocks {
+		b := &blocks[i]
+		low := from
+		if i > 0 {
+			low = lastLine(blocks[i-1].node, blocks[i-1].value) + 1
+		}
+		if b.line < low || b.line > to || b.col != blocks[0].col {
+			return nil, fmt.Errorf("line %d: cannot move this block as written; sort without moving blocks", b.line+1)
+		}
+		b.start = b.line
+		if b.line != keyLine {
+			b.start = w.commentsAbove(b.line, b.col, low, i == 0)
+		}
+	}
+	w.setEnds(blocks, to)
+	return blocks, nil
+}
+
+// setEnds ends each block where the next one starts, and the last one at the
+// end of its content, not looking below to. Trailing blank lines are left
+// between the blocks unless a block scalar keeps them.
+func (w *blockWriter) setEnds(blocks []block, to int) {
+	for i := range blocks {
+		b := &blocks[i]
+		last := lastLine(b.node, b.value)
+		if i+1 < len(blocks) {
+			b.end = blocks[i+1].start - 1
+		} else {
+			b.end = w.continuation(last, b.col, to)
+		}
+		if !w.keepsTrailingLines(b.value) {
+			for b.end > last && strings.TrimSpace(w.src[b.end]) == "" {
+				b.end--
+			}
+		}
+	}
+}
+
+// dashLine finds the line of lines holding the "-" (at col) that starts item.
+func dashLine(lines []string, item *yaml.Node, col int) int {
+	j := item.Line - 1
+	for j > 0 && !(len(lines[j]) > col && lines[j][col] == '-') {
+		j--
+	}
+	return j
+}
+
+// commentsAbove returns the first line of the comments directly above line
+// that belong to its block, not looking above low, the way bindGap splits
+// them. For the first entry of a block (first) that is all of them below the
+// last blank line; the comments above it are about the whole block and stay at
+// its top. For a later entry it is those from the first one indented no
+// deeper than col; the comments before end the block above. A "# ysort: on"
+// directive stays at the end of its region, so it is not part of the block.
+func (w *blockWriter) commentsAbove(line, col, low int, first bool) int {
+	gap := line
+	for gap > low && isBlankOrComment(w.scan[gap-1]) {
+		gap--
+	}
+	start := line
+	for j := line - 1; j >= gap; j-- {
+		t := strings.TrimSpace(w.scan[j])
+		switch {
+		case first && t == "":
+			j = gap // stop
+		case first:
+			start = j
+		case t != "" && leadingSpaces(w.scan[j]) <= col:
+			start = j
+		}
+	}
+	for j := start; j < line; j++ {
+		if d, ok := parseDirective(w.src[j]); ok && d.name == directiveOn {
+			start = j + 1
+		}
+	}
+	for start < line && strings.TrimSpace(w.src[start]) == "" {
+		start++
+	}
+	return start
+}
+
+// fitHead fits the comments above a block's key line, lines[:key], to where
+// the block now is, so they are read back as its own: at the top of its parent
+// (first) a blank line among them would leave the ones above it to the parent,
+// and below another entry the ones indented deeper than col would end that
+// entry. It returns the lines and the new index of the key line.
+func fitHead(lines []string, key, col int, first bool) ([]string, int) {
+	head := make([]string, 0, key)
+	for _, l := range lines[:key] {
+		n := leadingSpaces(l)
+		switch {
+		case first && strings.TrimSpace(l) == "":
+			continue
+		case !first && n > col && strings.HasPrefix(l[n:], "#"):
+			l = l[n-col:]
+		}
+		head = append(head, l)
+	}
+	return append(head, lines[key:]...), len(head)
+}
+
+// continuation returns the last line of a block whose own nodes end at last:
+// lines after it that are blank or indented deeper than col still belong to
+// it, e.g. the rest of a block scalar or a comment at the end of a nested map.
+func (w *blockWriter) continuation(last, col, to int) int {
+	end := last
+	for end+1 <= to {
+		l := w.src[end+1]
+		if strings.TrimSpace(l) != "" && (leadingSpaces(l) <= col || isDocumentMarker(l)) {
+			break
+		}
+		end++
+	}
+	return end
+}
+
+// keepsTrailingLines reports whether node is a block scalar with the "+"
+// indicator, whose trailing blank lines are part of its value.
+func (w *blockWriter) keepsTrailingLines(node *yaml.Node) bool {
+	if node.Kind != yaml.ScalarNode || node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		return false
+	}
+	header, ok := blockHeader(w.src[node.Line-1], node.Column)
+	return ok && strings.Contains(header, "+")
+}
+
+// lastLine returns the last source line (0-based) of any node below nodes.
+func lastLine(nodes ...*yaml.Node) int {
+	last := 0
+	for _, n := range nodes {
+		last = max(last, n.Line-1, lastLine(n.Content...))
+	}
+	return last
+}
+
+// verbatim returns source lines from..to with re-encoded flow collections.
+func (w *blockWriter) verbatim(from, to int) []string {
+	var out []string
+	for i := from; i <= to; i++ {
+		switch s, ok := w.splice[i]; {
+		case w.drop[i]:
+		case ok:
+			out = append(out, s)
+		default:
+			out = append(out, w.src[i])
+		}
+	}
+	return out
+}
+
+// spliceFlows re-encodes each flow collection below node whose order changed.
+func (w *blockWriter) spliceFlows(node *yaml.Node) error {
+	if node.Style&yaml.FlowStyle != 0 && (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) {
+		if !w.reordered(node) {
+			return nil
+		}
+		return w.spliceFlow(node)
+	}
+	for _, c := range node.Content {
+		if err := w.spliceFlows(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reordered reports whether sorting changed anything below node.
+func (w *blockWriter) reordered(node *yaml.Node) bool {
+	if !slices.Equal(w.order[node], node.Content) {
+		return true
+	}
+	for _, c := range node.Content {
+		if w.reordered(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *blockWriter) spliceFlow(node *yaml.Node) error {
+	line, col := node.Line-1, node.Column-1
+	if _, ok := w.splice[line]; ok || w.drop[line] {
+		return fmt.Errorf("line %d: cannot reorder two flow collections on one line; sort without moving blocks", node.Line)
+	}
+	endLine, endCol, err := w.flowEnd(line, col)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(uncommented(node))
+	if err != nil {
+		return fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	text := strings.TrimSuffix(string(out), "\n")
+	if strings.Contains(text, "\n") {
+		return fmt.Errorf("line %d: flow collection does not fit on one line; sort without moving blocks", node.Line)
+	}
+	w.splice[line] = w.src[line][:col] + text + w.src[endLine][endCol:]
+	for j := line + 1; j <= endLine; j++ {
+		w.drop[j] = true
+	}
+	return nil
+}
+
+// flowEnd finds the end of the flow collection that starts at line, col: the
+// line and the column just after its closing bracket.
+func (w *blockWriter) flowEnd(line, col int) (int, int, error) {
+	var f flowScan
+	for l := line; l < len(w.src); l++ {
+		i := 0
+		if l == line {
+			i = col
+		}
+		end, comment := f.scan(w.src[l], i)
+		if comment {
+			return 0, 0, fmt.Errorf("line %d: cannot reorder a flow collection with comments inside; sort without moving blocks", l+1)
+		}
+		if end >= 0 {
+			return l, end, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("line %d: unterminated flow collection", line+1)
+}
+
+// flowScan reads a flow collection line by line.
+type flowScan struct {
+	depth int  // brackets open
+	quote byte // the quote of the scalar being read, 0 outside quotes
+	prev  byte // last character outside quotes that is not a space
+}
+
+// scan reads s from i. It returns the index just after the bracket that
+// closes the collection, or -1 if the collection goes on below s, and whether
+// it stopped at a comment instead.
+func (f *flowScan) scan(s string, i int) (int, bool) {
+	for ; i < len(s); i++ {
+		if f.quote != 0 {
+			i = f.skipQuoted(s, i)
+			continue
+		}
+		c := s[i]
+		switch {
+		case (c == '\'' || c == '"') && strings.IndexByte("[{,:", f.prev) >= 0:
+			f.quote = c
+		case c == '#' && (i == 0 || isSpace(s[i-1])):
+			return -1, true
+		case c == '{' || c == '[':
+			f.depth++
+		case c == '}' || c == ']':
+			f.depth--
+			if f.depth == 0 {
+				return i + 1, false
+			}
+		}
+		if !isSpace(c) {
+			f.prev = c
+		}
+	}
+	return -1, false
+}
+
+// skipQuoted returns the index of the quote that ends the scalar being read
+// from s[i], or len(s) if the scalar goes on below s.
+func (f *flowScan) skipQuoted(s string, i int) int {
+	end, closed := quoteEnd(s, i, f.quote)
+	if closed {
+		f.quote = 0
+	}
+	return end
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// uncommented returns a copy of node without comments, which the source line
+// around a re-encoded flow collection still has.
+func uncommented(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.HeadComment, c.LineComment, c.FootComment = "", "", ""
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, n := range node.Content {
+		c.Content[i] = uncommented(n)
+	}
+	return &c
+}
@@ -0,0 +1,194 @@
+package sorter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is one step from the document root to a node: a mapping key, or
+// a sequence element when index >= 0.
+type pathSegment struct {
+	key   string
+	index int
+}
+
+func keySegment(key string) pathSegment { return pathSegment{key: key, index: -1} }
+
+func indexSegment(i int) pathSegment { return pathSegment{index: i} }
+
+func (s pathSegment) isIndex() bool { return s.index >= 0 }
+
+// formatPath renders a node path the way users write it, e.g.
+// "spec.containers[0].env".
+func formatPath(path []pathSegment) string {
+	var sb strings.Builder
+	for _, s := range path {
+		if s.isIndex() {
+			fmt.Fprintf(&sb, "[%d]", s.index)
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(s.key)
+	}
+	return sb.String()
+}
+
+type patternKind int
+
+const (
+	patternKey      patternKind = iota // literal mapping key
+	patternAnyKey                      // "*": any single mapping key
+	patternAnyDepth                    // "**": zero or more segments of any kind
+	patternAnyIndex                    // "[]" or "[*]": any sequence element
+	patternIndex                       // "[N]": the element at index N
+)
+
+type patternSegment struct {
+	kind  patternKind
+	key   string
+	index int
+}
+
+// pathPattern is a compiled path such as "spec.template.spec.containers[*].env"
+// or "**.env".
+type pathPattern struct {
+	source   string
+	segments []patternSegment
+}
+
+// compilePathPattern parses a dot-separated path pattern. Segments are mapping
+// keys, "*" (any one key) or "**" (any depth); "[]", "[*]" or "[N]" after a
+// segment (or on their own) select sequence elements.
+func compilePathPattern(source string) (pathPattern, error) {
+	p := pathPattern{source: source}
+	if strings.TrimSpace(source) == "" {
+		return p, fmt.Errorf("empty path")
+	}
+	for _, part := range strings.Split(source, ".") {
+		name, indexes, ok := splitIndexes(part)
+		if !ok {
+			return p, fmt.Errorf("invalid index in path %q", source)
+		}
+
+		switch name {
+		case "":
+			if len(indexes) == 0 {
+				return p, fmt.Errorf("empty segment in path %q", source)
+			}
+		case "*":
+			p.segments = append(p.segments, patternSegment{kind: patternAnyKey})
+		case "**":
+			p.segments = append(p.segments, patternSegment{kind: patternAnyDepth})
+		default:
+			p.segments = append(p.segments, patternSegment{kind: patternKey, key: name})
+		}
+
+		for _, idx := range indexes {
+			if idx == "" || idx == "*" {
+				p.segments = append(p.segments, patternSegment{kind: patternAnyIndex})
+				continue
+			}
+			n, err := strconv.Atoi(idx)
+			if err != nil || n < 0 {
+				return p, fmt.Errorf("invalid index %q in path %q", idx, source)
+			}
+			p.segments = append(p.segments, patternSegment{kind: patternIndex, index: n})
+		}
+	}
+	return p, nil
+}
+
+// splitIndexes splits a path segment such as "containers[*][0]" into its
+// key and the contents of its brackets.
+func splitIndexes(part string) (string, []string, bool) {
+	i := strings.IndexByte(part, '[')
+	if i < 0 {
+		return part, nil, true
+	}
+	var indexes []string
+	for rest := part[i:]; rest != ""; {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return "", nil, false
+		}
+		indexes = append(indexes, rest[1:end])
+		rest = rest[end+1:]
+	}
+	return part[:i], indexes, true
+}
+
+// match reports whether the pattern selects the node at path. A key segment
+// may step over sequence elements in path without a matching "[*]", so plain
+// dotted paths such as "spec.containers.env" keep matching lists inside list
+// elements.
+func (p pathPattern) match(path []pathSegment) bool {
+	return matchPattern(p.segments, path)
+}
+
+func matchPattern(pattern []patternSegment, path []pathSegment) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	switch seg := pattern[0]; seg.kind {
+	case patternAnyDepth:
+		for i := 0; i <= len(path); i++ {
+			if matchPattern(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	case patternKey, patternAnyKey:
+		if len(path) == 0 {
+			return false
+		}
+		if path[0].isIndex() {
+			return matchPattern(pattern, path[1:])
+		}
+		if seg.kind == patternKey && path[0].key != seg.key {
+			return false
+		}
+		return matchPattern(pattern[1:], path[1:])
+	default:
+		if len(path) == 0 || !path[0].isIndex() {
+			return false
+		}
+		if seg.kind == patternIndex && path[0].index != seg.index {
+			return false
+		}
+		return matchPattern(pattern[1:], path[1:])
+	}
+}
+
+// specificity ranks patterns when several match the same node: more literal
+// keys win, then more exact indexes, then more single-segment wildcards, then
+// fewer "**".
+func (p pathPattern) specificity() [4]int {
+	var s [4]int
+	for _, seg := range p.segments {
+		switch seg.kind {
+		case patternKey:
+			s[0]++
+		case patternIndex:
+			s[1]++
+		case patternAnyKey, patternAnyIndex:
+			s[2]++
+		case patternAnyDepth:
+			s[3]--
+		}
+	}
+	return s
+}
+
+// moreSpecific reports whether p should win over q when both match.
+func (p pathPattern) moreSpecific(q pathPattern) bool {
+	ps, qs := p.specificity(), q.specificity()
+	for i := range ps {
+		if ps[i] != qs[i] {
+			return ps[i] > qs[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package sorter
+
+import "testing"
+
+func TestPathPatternMatch(t *testing.T) {
+	containersEnv := []pathSegment{
+		keySegment("spec"), keySegment("template"), keySegment("spec"),
+		keySegment("containers"), indexSegment(1), keySegment("env"),
+	}
+	tests := []struct {
+		pattern string
+		path    []pathSegment
+		want    bool
+	}{
+		{"spec.template.spec.containers[*].env", containersEnv, true},
+		{"spec.template.spec.containers[].env", containersEnv, true},
+		{"spec.template.spec.containers[1].env", containersEnv, true},
+		{"spec.template.spec.containers[0].env", containersEnv, false},
+		{"spec.template.spec.containers.env", containersEnv, true},
+		{"spec.*.spec.containers[*].env", containersEnv, true},
+		{"**.env", containersEnv, true},
+		{"**.containers[*].env", containersEnv, true},
+		{"spec.**.env", containersEnv, true},
+		{"**.volumes", containersEnv, false},
+		{"spec.template.spec.containers", containersEnv, false},
+		{"spec.egress", []pathSegment{keySegment("spec"), keySegment("egress")}, true},
+		{"spec.*", []pathSegment{keySegment("spec"), keySegment("egress")}, true},
+		{"*", []pathSegment{keySegment("spec"), keySegment("egress")}, false},
+	}
+
+	for _, tt := range tests {
+		p, err := compilePathPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("compilePathPattern(%q) error = %v", tt.pattern, err)
+		}
+		if got := p.match(tt.path); got != tt.want {
+			t.Errorf("%q.match(%s) = %v, want %v", tt.pattern, formatPath(tt.path), got, tt.want)
+		}
+	}
+}
+
+func TestCompilePathPatternErrors(t *testing.T) {
+	for _, pattern := range []string{"", "spec..env", "spec.containers[x]", "spec.containers[*"} {
+		if _, err := compilePathPattern(pattern); err == nil {
+			t.Errorf("compilePathPattern(%q) should fail", pattern)
+		}
+	}
+}
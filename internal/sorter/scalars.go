@@ -0,0 +1,251 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yaml.v3 rewraps folded (">") scalars and writes a blank line after them, so
+// literal and folded block scalars are not left to the encoder. Before
+// encoding, each one is replaced with a plain placeholder; afterwards the
+// placeholder is swapped for the block's source text, re-indented one level
+// below its key or list item.
+
+// blockScalarToken prefixes the placeholders, unless the input contains it
+// (see uniqueToken); a counter makes each unique.
+const blockScalarToken = "ysort_block_scalar_"
+
+// blockScalar is the source text of one literal or folded scalar.
+type blockScalar struct {
+	header string   // the indicator as written, e.g. "|", ">-" or "|2+"
+	parent int      // source column of the key or "-" that owns the block
+	item   bool     // the block is a list item rather than a mapping value
+	body   []string // the content lines as written
+	indent int      // source column of the content, -1 if it has none
+}
+
+// blockScalars collects the block scalars of the documents being encoded.
+type blockScalars struct {
+	lines  []string // the whole source split on "\n"
+	token  string   // placeholder prefix
+	blocks map[string]blockScalar
+}
+
+func newBlockScalars(lines []string) *blockScalars {
+	return &blockScalars{lines: lines, token: blockScalarToken, blocks: make(map[string]blockScalar)}
+}
+
+// hold replaces every block scalar below node with a placeholder.
+func (b *blockScalars) hold(node *yaml.Node) {
+	eachBlockScalar(node, -1, false, func(node *yaml.Node, parent int, item bool) {
+		block, ok := b.source(node, parent, item)
+		if !ok {
+			return
+		}
+		token := fmt.Sprintf("%s%d", b.token, len(b.blocks))
+		b.blocks[token] = block
+		node.Value = token
+		node.Style &^= yaml.LiteralStyle | yaml.FoldedStyle
+	})
+}
+
+// eachBlockScalar calls fn for every literal or folded scalar below node.
+// parent is the column (0-based) of the collection holding the scalar, or -1
+// at the root; the block's content is the lines after its header indented
+// deeper than that. item tells whether the scalar is a list item.
+func eachBlockScalar(node *yaml.Node, parent int, item bool, fn func(node *yaml.Node, parent int, item bool)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, c := range node.Content {
+			eachBlockScalar(c, -1, false, fn)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			eachBlockScalar(node.Content[i], node.Column-1, false, fn)
+		}
+	case yaml.SequenceNode:
+		for _, c := range node.Content {
+			eachBlockScalar(c, node.Column-1, true, fn)
+		}
+	case yaml.ScalarNode:
+		if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			fn(node, parent, item)
+		}
+	}
+}
+
+// bodyLines maps the 0-based source line of each content line of the block
+// scalars in docs to the column of its block's content (-1 if it has none).
+func (b *blockScalars) bodyLines(docs []*yaml.Node) map[int]int {
+	body := make(map[int]int)
+	for _, doc := range docs {
+		eachBlockScalar(doc, -1, false, func(node *yaml.Node, parent int, item bool) {
+			if block, ok := b.source(node, parent, item); ok {
+				for i := range block.body {
+					body[node.Line+i] = block.indent
+				}
+			}
+		})
+	}
+	return body
+}
+
+// source reads the header and content lines of the block scalar at node.
+func (b *blockScalars) source(node *yaml.Node, parent int, item bool) (blockScalar, bool) {
+	if node.Line < 1 || node.Line > len(b.lines) {
+		return blockScalar{}, false
+	}
+	header, ok := blockHeader(b.lines[node.Line-1], node.Column)
+	if !ok {
+		return blockScalar{}, false
+	}
+
+	block := blockScalar{header: header, parent: max(parent, 0), item: item, indent: -1}
+	for _, l := range b.lines[node.Line:] {
+		if strings.TrimSpace(l) != "" {
+			if leadingSpaces(l) <= parent || isDocumentMarker(l) {
+				break
+			}
+			if block.indent < 0 {
+				block.indent = leadingSpaces(l)
+			}
+		}
+		block.body = append(block.body, l)
+	}
+	// Trailing blank lines separate the block from what follows, unless the
+	// "+" indicator keeps them as content.
+	if !strings.Contains(block.header, "+") {
+		for len(block.body) > 0 && strings.TrimSpace(block.body[len(block.body)-1]) == "" {
+			block.body = block.body[:len(block.body)-1]
+		}
+	}
+	return block, true
+}
+
+// blockHeader returns the indicator of the block scalar that starts at column
+// (1-based) of line, e.g. "|-" for "key: &a |- # comment".
+func blockHeader(line string, column int) (string, bool) {
+	from := min(max(column-1, 0), len(line))
+	start := strings.IndexAny(line[from:], "|>")
+	if start < 0 {
+		return "", false
+	}
+	start += from
+	end := start + 1
+	for end < len(line) && strings.IndexByte("0123456789+-", line[end]) >= 0 {
+		end++
+	}
+	return line[start:end], true
+}
+
+// restore puts the source text of each held block scalar back into out, its
+// content indent spaces deeper than its key or list item. A block with an
+// explicit indentation indicator, e.g. "|2", moves with its key instead.
+func (b *blockScalars) restore(out []byte, indent int) []byte {
+	if len(b.blocks) == 0 {
+		return out
+	}
+	lines := strings.Split(string(out), "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		block, token, ok := b.find(line)
+		if !ok {
+			result = append(result, line)
+			continue
+		}
+		result = append(result, strings.Replace(line, token, block.header, 1))
+		owner := ownerColumn(line, block.item)
+		shift := owner - block.parent
+		if block.indent >= 0 && !strings.ContainsAny(block.header, "123456789") {
+			shift = owner + indent - block.indent
+		}
+		for _, l := range block.body {
+			switch {
+			case l == "":
+			case shift > 0:
+				l = strings.Repeat(" ", shift) + l
+			case shift < 0:
+				l = l[min(-shift, leadingSpaces(l)):]
+			}
+			result = append(result, l)
+		}
+	}
+	return []byte(strings.Join(result, "\n"))
+}
+
+// find returns the block whose placeholder is on line.
+func (b *blockScalars) find(line string) (blockScalar, string, bool) {
+	i := strings.Index(line, b.token)
+	if i < 0 {
+		return blockScalar{}, "", false
+	}
+	j := i + len(b.token)
+	for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+		j++
+	}
+	token := line[i:j]
+	block, ok := b.blocks[token]
+	return block, token, ok
+}
+
+// uniqueToken returns base, or base with a number appended, such that text
+// does not contain it. Placeholders start with the result, so none of them
+// can be mistaken for something the input says.
+func uniqueToken(base, text string) string {
+	token := base
+	for n := 1; strings.Contains(text, token); n++ {
+		token = fmt.Sprintf("%s%d_", base, n)
+	}
+	return token
+}
+
+// inputText returns the source lines together with every value, comment, tag
+// and anchor in docs. A quoted scalar's value need not appear in the source as
+// it is written out ("a\x5fb" holds "a_b").
+func inputText(src []string, docs []*yaml.Node) string {
+	var sb strings.Builder
+	sb.WriteString(strings.Join(src, "\n"))
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		for _, t := range []string{node.Value, node.Tag, node.Anchor, node.HeadComment, node.LineComment, node.FootComment} {
+			sb.WriteByte('\n')
+			sb.WriteString(t)
+		}
+		for _, c := range node.Content {
+			walk(c)
+		}
+	}
+	for _, doc := range docs {
+		walk(doc)
+	}
+	return sb.String()
+}
+
+// ownerColumn returns the column of the key, or of the innermost "-" for a
+// list item, at the start of an output line.
+func ownerColumn(line string, item bool) int {
+	col := leadingSpaces(line)
+	for strings.HasPrefix(line[col:], "- ") {
+		col += 2
+	}
+	if item && col >= 2 {
+		return col - 2
+	}
+	return col
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// isDocumentMarker reports whether line starts or ends a document.
+func isDocumentMarker(line string) bool {
+	for _, m := range []string{"---", "..."} {
+		if rest, ok := strings.CutPrefix(line, m); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return true
+		}
+	}
+	return false
+}
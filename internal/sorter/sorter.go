@@ -1,7 +1,11 @@
 package sorter
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"sort"
 	"strings"
 
@@ -9,7 +13,8 @@ import (
 )
 
 // K8sRootKeyOrder defines the preferred order of top-level keys in a Kubernetes
-// manifest. Keys not in this list are sorted alphabetically after these.
+// manifest. Keys not in this list are sorted alphabetically after these. It is
+// the First list of the key order rule that Options.K8sRoot adds.
 var K8sRootKeyOrder = []string{"apiVersion", "kind", "metadata", "spec", "data", "status"}
 
 // Options configures how YAML is sorted.
@@ -17,8 +22,52 @@ type Options struct {
 	// K8sRoot: root mapping uses fixed K8s key order (apiVersion, kind, metadata, spec, …).
 	K8sRoot bool
 	// ListSortKeys: for each path (e.g. "spec.egress"), sort that list by the given key (e.g. "name") in each element.
-	// Path is dot-separated from document root, e.g. "spec.ingress", "spec.egress".
+	// Path is dot-separated from document root, e.g. "spec.ingress", "spec.egress". It may use
+	// "*" (any key), "**" (any depth) and "[*]" (any list element), e.g. "spec.containers[*].env"
+	// or "**.env"; when several paths match a list, the most specific one wins.
 	ListSortKeys map[string]string // path -> key
+	// ListSortRules are list sort rules with several keys or other settings. They
+	// apply after ListSortKeys; among equally specific matching paths the later rule wins.
+	ListSortRules []ListSortRule
+	// KeyOrder pins the order of some keys in the mappings its rules select;
+	// other mappings are sorted alphabetically. The most specific matching
+	// path wins, and the later rule on a tie.
+	KeyOrder []KeyOrderRule
+	// Exclude keeps the nodes its rules select in their original order. If
+	// several rules match a node, ExcludeSubtree wins over ExcludeNode.
+	Exclude []ExcludeRule
+	// Anchors says what to do when sorting would put an alias before its
+	// anchor: move the anchor definition (the default) or fail.
+	Anchors AnchorPolicy
+	// Dedupe keeps one occurrence of a duplicate mapping key. By default a
+	// duplicate key is an error.
+	Dedupe DedupePolicy
+	// Indent is the number of spaces per nesting level in the output. Zero
+	// reuses the indentation detected in the input. Widths outside
+	// MinIndent..MaxIndent are clamped to that range.
+	Indent int
+	// SequenceIndent controls whether block sequences under a mapping key are
+	// indented. The zero value reuses the input's style.
+	SequenceIndent SequenceIndent
+	// LineEndings selects "\n" or "\r\n" line breaks; the zero value keeps
+	// the input's. A byte order mark and a missing final newline are always kept.
+	LineEndings LineEndings
+	// KeepBlankLines keeps a blank line above each key or list item that had
+	// one in the input; the blank line moves with its node.
+	KeepBlankLines bool
+	// MoveBlocks writes the result by moving the source lines of each key and
+	// list item instead of encoding the sorted tree, so lines that did not
+	// move stay byte for byte the same. Indent, SequenceIndent and
+	// KeepBlankLines do not apply, and an alias that would come before its
+	// anchor is always an error.
+	MoveBlocks bool
+	// DebugComments, if set, receives a line for every comment in the input
+	// naming the node it was bound to, e.g. "12: # replicas -> above spec.replicas".
+	DebugComments io.Writer
+	// Verify decodes the output again and fails if it does not hold the same
+	// data as the input. Mapping order does not count, nor the order of lists
+	// that a list sort rule reordered.
+	Verify bool
 }
 
 // SortYAML sorts a YAML document recursively: at each level, mapping keys are
@@ -35,233 +84,562 @@ func SortYAMLK8s(data []byte) ([]byte, error) {
 	return SortYAMLWithOptions(data, Options{K8sRoot: true})
 }
 
-// SortYAMLWithOptions sorts a YAML document using the given options (K8s root order,
-// and optional list sort keys from a config file).
-func SortYAMLWithOptions(data []byte, opts Options) ([]byte, error) {
-	var node yaml.Node
-	if err := yaml.Unmarshal(data, &node); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+// SortYAMLWithOptions sorts a YAML stream using the given options (K8s root order,
+// and optional list sort keys from a config file). Every document of a
+// multi-document stream is sorted on its own and written back in input order,
+// separated by "---".
+func SortYAMLWithOptions(input []byte, opts Options) ([]byte, error) {
+	ctx, err := newSortContext(opts)
+	if err != nil {
+		return nil, err
 	}
-
-	if node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
-		return nil, fmt.Errorf("invalid YAML document")
+	data, format := detectFormat(input)
+	src := strings.Split(string(data), "\n")
+	frames, lines := splitFrames(src)
+	docs, err := decodeDocuments([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return nil, err
 	}
+	if len(docs) == 0 {
+		return input, nil // only comments and blank lines
+	}
+	if len(frames) != len(docs) {
+		frames = make([]docFrame, len(docs))
+	}
+	reserved := inputText(src, docs)
 
-	root := node.Content[0]
-	normalizeLeadingComments(root, data)
-	sortNodeWithPath(root, nil, opts)
-
-	result, err := yaml.Marshal(&node)
+	var order sourceOrder
+	if opts.MoveBlocks {
+		order = make(sourceOrder)
+		order.record(docs...)
+	}
+	ctx.directives, err = scanDirectives(src, docs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal YAML: %w", err)
+		return nil, err
+	}
+	if ctx.directives.ignoreFile {
+		return input, nil
 	}
-	return result, nil
-}
 
-// sortNodeWithPath recursively sorts the tree. path is the dot-separated path from
-// document root to this node (e.g. ["spec", "egress"]). Used to apply listSortKeys.
-func sortNodeWithPath(node *yaml.Node, path []string, opts Options) {
-	if node == nil {
-		return
+	layout := resolveLayout(docs, opts)
+	blank := blankLines{keep: opts.KeepBlankLines, marker: uniqueToken(blankLineToken, reserved)}
+	attachDocumentComments(docs, lines, blank)
+	comments := bindComments(docs, src, lines, blank)
+	comments.token = uniqueToken(commentToken, reserved)
+	reordered, err := sortDocuments(docs, frames, ctx, opts, blank)
+	if err != nil {
+		return nil, err
 	}
-	switch node.Kind {
-	case yaml.MappingNode:
-		sortMappingNodeWithPath(node, path, opts)
-	case yaml.SequenceNode:
-		sortSequenceNodeWithPath(node, path, opts)
+	if opts.DebugComments != nil {
+		comments.trace(opts.DebugComments, docs)
 	}
-}
 
-func sortMappingNodeWithPath(node *yaml.Node, path []string, opts Options) {
-	if node.Kind != yaml.MappingNode || len(node.Content)%2 != 0 {
-		return
+	var out []byte
+	if opts.MoveBlocks {
+		out, err = moveBlocks(docs, src, lines, order)
+	} else {
+		out, err = encodeStream(docs, frames, lines, layout, comments, reserved)
 	}
-	kvPairs := extractKeyValuePairs(node)
-	for _, p := range kvPairs {
-		sortNodeWithPath(p.value, append(path, p.key.Value), opts)
+	if err != nil {
+		return nil, err
 	}
-	// Root mapping and K8s mode: use fixed key order; otherwise alphabetical
-	if len(path) == 0 && opts.K8sRoot {
-		sort.Slice(kvPairs, func(i, j int) bool {
-			return k8sRootKeyLess(kvPairs[i].key.Value, kvPairs[j].key.Value)
-		})
-	} else {
-		sort.Slice(kvPairs, func(i, j int) bool {
-			return kvPairs[i].key.Value < kvPairs[j].key.Value
-		})
+	if err := comments.verify(out, docs); err != nil {
+		return nil, err
 	}
-	rebuildMappingContent(node, kvPairs)
+	if opts.Verify {
+		if err := verifyOutput(src, out, reordered, opts.Dedupe); err != nil {
+			return nil, err
+		}
+	}
+	return format.restore(out, opts.LineEndings), nil
 }
 
-func sortSequenceNodeWithPath(node *yaml.Node, path []string, opts Options) {
-	if node.Kind != yaml.SequenceNode {
-		return
+// sortDocuments sorts every document and returns, per document, the paths of
+// the lists a list sort rule reordered.
+func sortDocuments(docs []*yaml.Node, frames []docFrame, ctx *sortContext, opts Options, blank blankLines) ([]map[string]bool, error) {
+	anchors := opts.Anchors
+	if opts.MoveBlocks {
+		// Moving an anchor definition would mean rewriting the source.
+		anchors = AnchorError
+	}
+	reordered := make([]map[string]bool, len(docs))
+	for i, doc := range docs {
+		ctx.reordered = make(map[string]bool)
+		reordered[i] = ctx.reordered
+		root := doc.Content[0]
+		if err := checkDuplicateKeys(root, nil, opts.Dedupe); err != nil {
+			return nil, err
+		}
+		// Directives in the document's leading comments, including those
+		// above its "---", apply to the root node.
+		d := commentDirectives(strings.Join(frames[i].preamble, "\n") + "\n" + doc.HeadComment)
+		if d.ignore {
+			continue
+		}
+		if d.order != nil {
+			ctx.pinOrder(root, d.order)
+		}
+		if err := sortNodeWithPath(root, nil, ctx); err != nil {
+			return nil, err
+		}
+		if opts.KeepBlankLines {
+			blank.dropMoved(root)
+		}
+		if err := fixAnchorOrder(doc, anchors); err != nil {
+			if opts.MoveBlocks {
+				return nil, fmt.Errorf("%w; moving blocks cannot move the anchor", err)
+			}
+			return nil, err
+		}
+		untagMergeKeys(doc)
 	}
-	pathStr := strings.Join(path, ".")
-	if key, ok := opts.ListSortKeys[pathStr]; ok {
-		// Sort this list by each element's key (e.g. "name")
-		sort.Slice(node.Content, func(i, j int) bool {
-			vi := getScalarFromMapping(node.Content[i], key)
-			vj := getScalarFromMapping(node.Content[j], key)
-			return vi < vj
-		})
+	return reordered, nil
+}
+
+// encodeStream writes docs with yaml.v3, each in the frame copied from the
+// source, and puts back the comments, block scalars and blank lines held from
+// the encoder. reserved is the input text placeholders must not occur in.
+func encodeStream(docs []*yaml.Node, frames []docFrame, lines []string, layout layout, comments *commentSet, reserved string) ([]byte, error) {
+	comments.hold()
+	blocks := newBlockScalars(lines)
+	blocks.token = uniqueToken(blockScalarToken, reserved)
+	var buf bytes.Buffer
+	for i, doc := range docs {
+		blocks.hold(doc)
+		frame := frames[i]
+		writeLines(&buf, frame.preamble)
+		if i > 0 && !frame.explicit && len(frames[i-1].trailer) == 0 {
+			buf.WriteString("---\n")
+		}
+		text, err := encodeDocument(doc, layout.indent)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(frame.tags.shorten(text))
+		writeLines(&buf, frame.trailer)
 	}
-	for _, child := range node.Content {
-		sortNodeWithPath(child, path, opts)
+	out := alignNested(buf.Bytes(), layout.indent)
+	if layout.indentless {
+		out = dedentSequences(out, layout.indent)
 	}
+	out = comments.restore(out, layout.indent)
+	out = blocks.restore(out, layout.indent)
+	return comments.blank.restore(out), nil
 }
 
-// getScalarFromMapping returns the scalar value for key in the mapping node, or "" if not found.
-func getScalarFromMapping(node *yaml.Node, key string) string {
-	if node == nil || node.Kind != yaml.MappingNode {
-		return ""
+// encodeDocument encodes one document. Each document gets its own encoder: a
+// shared one writes a document's head comment above the "---" separator,
+// where it would be read back as part of the previous document.
+func encodeDocument(doc *yaml.Node, indent int) (string, error) {
+	var body bytes.Buffer
+	enc := yaml.NewEncoder(&body)
+	enc.SetIndent(indent)
+	if err := enc.Encode(doc); err != nil {
+		return "", fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("failed to marshal YAML: %w", err)
+	}
+	text := body.String()
+	if root := doc.Content[0]; root.Kind == yaml.ScalarNode && root.Tag == "!!null" && root.Value == "" {
+		// An empty document: the encoder writes its missing value as a blank
+		// line.
+		text = strings.TrimLeft(text, "\n")
+	}
+	return text, nil
+}
+
+// decodeDocuments parses every document in a YAML stream; one of only comments
+// and blank lines has none. Line numbers on the returned nodes are relative to
+// the start of data, not to each document.
+func decodeDocuments(data []byte) ([]*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []*yaml.Node
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+		}
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+			return nil, fmt.Errorf("invalid YAML document")
+		}
+		docs = append(docs, &doc)
 	}
-	for i := 0; i < len(node.Content)-1; i += 2 {
-		if node.Content[i].Value == key {
-			v := node.Content[i+1]
-			if v.Kind == yaml.ScalarNode {
-				return v.Value
+	return docs, nil
+}
+
+// attachDocumentComments binds the comment block that sits between a
+// document's start and its root node to the document itself when a blank line
+// separates it from the first key. The separating blank line is replaced in
+// lines so the first key's leading-comment scan stops at it and the block is
+// not copied onto the key as well. Blank lines inside the block are written as
+// blank.join writes them.
+func attachDocumentComments(docs []*yaml.Node, lines []string, blank blankLines) {
+	for _, doc := range docs {
+		rootLine := doc.Content[0].Line - 1
+		if rootLine <= 0 || rootLine > len(lines) {
+			continue
+		}
+		start := rootLine
+		for start > 0 && isBlankOrComment(lines[start-1]) {
+			start--
+		}
+		fence := -1
+		for j := rootLine - 1; j > start; j-- {
+			if strings.TrimSpace(lines[j]) == "" && hasCommentLine(lines[start:j]) {
+				fence = j
+				break
 			}
-			return ""
 		}
+		if fence < 0 {
+			continue
+		}
+		head := make([]string, 0, fence-start)
+		for _, l := range lines[start:fence] {
+			head = append(head, strings.TrimSpace(l))
+		}
+		for len(head) > 0 && head[0] == "" {
+			head = head[1:]
+		}
+		for len(head) > 0 && head[len(head)-1] == "" {
+			head = head[:len(head)-1]
+		}
+		doc.HeadComment = blank.join(head)
+		lines[fence] = "---"
 	}
-	return ""
 }
 
-func k8sRootKeyLess(a, b string) bool {
-	idxA := indexOfK8sRootKey(a)
-	idxB := indexOfK8sRootKey(b)
-	if idxA >= 0 && idxB >= 0 {
-		return idxA < idxB
+func isBlankOrComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+func hasCommentLine(lines []string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), "#") {
+			return true
+		}
 	}
-	if idxA >= 0 {
-		return true
+	return false
+}
+
+// sortContext carries the options of one SortYAMLWithOptions call together
+// with the list sort, key order and exclude rules compiled from them, and the
+// in-file directives of the input being sorted.
+type sortContext struct {
+	opts       Options
+	listRules  []listRule
+	keyOrders  []keyOrderRule
+	excludes   []excludeRule
+	directives fileDirectives
+	pinned     map[*yaml.Node]keyOrderRule // mappings with an order directive
+	reordered  map[string]bool             // source paths of the lists sorted in the current document
+}
+
+func newSortContext(opts Options) (*sortContext, error) {
+	ctx := &sortContext{opts: opts, pinned: make(map[*yaml.Node]keyOrderRule)}
+	paths := make([]string, 0, len(opts.ListSortKeys))
+	for p := range opts.ListSortKeys {
+		paths = append(paths, p)
 	}
-	if idxB >= 0 {
-		return false
+	// Sorted so that ties between equally specific patterns resolve the same way every run.
+	sort.Strings(paths)
+	rules := make([]ListSortRule, 0, len(paths)+len(opts.ListSortRules))
+	for _, p := range paths {
+		rules = append(rules, ListSortRule{Path: p, Keys: []string{opts.ListSortKeys[p]}})
 	}
-	return a < b
+	rules = append(rules, opts.ListSortRules...)
+
+	for _, r := range rules {
+		rule, err := compileListRule(r)
+		if err != nil {
+			return nil, err
+		}
+		ctx.listRules = append(ctx.listRules, rule)
+	}
+
+	orders := opts.KeyOrder
+	if opts.K8sRoot {
+		orders = append([]KeyOrderRule{k8sRootKeyOrder()}, orders...)
+	}
+	for _, r := range orders {
+		rule, err := compileKeyOrderRule(r)
+		if err != nil {
+			return nil, err
+		}
+		ctx.keyOrders = append(ctx.keyOrders, rule)
+	}
+	for _, r := range opts.Exclude {
+		rule, err := compileExcludeRule(r)
+		if err != nil {
+			return nil, err
+		}
+		ctx.excludes = append(ctx.excludes, rule)
+	}
+	return ctx, nil
 }
 
-func indexOfK8sRootKey(key string) int {
-	for i, k := range K8sRootKeyOrder {
-		if k == key {
-			return i
+// excluded reports whether the node at path is excluded from sorting, and
+// with which scope.
+func (c *sortContext) excluded(path []pathSegment) (ExcludeScope, bool) {
+	scope, found := ExcludeNode, false
+	for _, r := range c.excludes {
+		if r.pattern.match(path) {
+			found = true
+			if r.scope == ExcludeSubtree {
+				return ExcludeSubtree, true
+			}
 		}
 	}
-	return -1
+	return scope, found
 }
 
-type kvPair struct {
-	key   *yaml.Node
-	value *yaml.Node
+// listRuleFor returns the most specific list sort rule matching path; on a
+// tie the rule that comes last wins.
+func (c *sortContext) listRuleFor(path []pathSegment) (listRule, bool) {
+	return bestMatch(c.listRules, func(r listRule) pathPattern { return r.pattern }, path)
 }
 
-func extractKeyValuePairs(node *yaml.Node) []kvPair {
-	n := len(node.Content) / 2
-	pairs := make([]kvPair, 0, n)
-	for i := 0; i < len(node.Content); i += 2 {
-		pairs = append(pairs, kvPair{
-			key:   node.Content[i],
-			value: node.Content[i+1],
-		})
+// keyOrderFor returns the key order rule for the mapping node at path: the
+// one pinned by an order directive, or else the best match chosen like
+// listRuleFor.
+func (c *sortContext) keyOrderFor(node *yaml.Node, path []pathSegment) (keyOrderRule, bool) {
+	if r, ok := c.pinned[node]; ok {
+		return r, true
 	}
-	return pairs
+	return bestMatch(c.keyOrders, func(r keyOrderRule) pathPattern { return r.pattern }, path)
 }
 
-func rebuildMappingContent(node *yaml.Node, pairs []kvPair) {
-	node.Content = make([]*yaml.Node, 0, len(pairs)*2)
-	for _, p := range pairs {
-		node.Content = append(node.Content, p.key, p.value)
+// pinOrder applies an order directive to node, if it is a mapping. The keys
+// were validated by scanDirectives.
+func (c *sortContext) pinOrder(node *yaml.Node, keys []string) {
+	if node.Kind != yaml.MappingNode {
+		return
 	}
+	rank, _ := rankKeys(keys, nil)
+	c.pinned[node] = keyOrderRule{rank: rank}
 }
 
-// normalizeLeadingComments ensures comments that appear directly above nodes in
-// source text stay attached to those nodes, including blank separators inside a
-// comment block.
-func normalizeLeadingComments(node *yaml.Node, data []byte) {
-	lines := strings.Split(string(data), "\n")
-	normalizeNodeLeadingComments(node, lines)
+// entryDirectives returns the directives for a mapping entry or list item
+// whose leading comment is comment and which starts at line. skip means the
+// entry keeps its subtree as written; frozen means it also keeps its position
+// because it lies in an off region.
+func (c *sortContext) entryDirectives(comment string, line int) (d nodeDirectives, skip, frozen bool) {
+	d = commentDirectives(comment)
+	frozen = c.directives.inRegion(line)
+	return d, frozen || d.ignore, frozen
 }
 
-func normalizeNodeLeadingComments(node *yaml.Node, lines []string) {
-	if node == nil {
-		return
+func bestMatch[R any](rules []R, patternOf func(R) pathPattern, path []pathSegment) (R, bool) {
+	var best R
+	found := false
+	for _, r := range rules {
+		p := patternOf(r)
+		if !p.match(path) {
+			continue
+		}
+		if !found || !patternOf(best).moreSpecific(p) {
+			best, found = r, true
+		}
 	}
+	return best, found
+}
 
+// sortNodeWithPath recursively sorts the tree. path leads from the document
+// root to this node (e.g. spec, egress); it is used to apply list sort rules.
+func sortNodeWithPath(node *yaml.Node, path []pathSegment, ctx *sortContext) error {
+	if node == nil {
+		return nil
+	}
+	scope, excluded := ctx.excluded(path)
+	if excluded && scope == ExcludeSubtree {
+		return nil
+	}
 	switch node.Kind {
 	case yaml.MappingNode:
-		normalizeMappingLeadingComments(node, lines)
-		for i := 1; i < len(node.Content); i += 2 {
-			normalizeNodeLeadingComments(node.Content[i], lines)
-		}
+		return sortMappingNodeWithPath(node, path, ctx, !excluded)
 	case yaml.SequenceNode:
-		normalizeSequenceLeadingComments(node, lines)
-		for _, item := range node.Content {
-			normalizeNodeLeadingComments(item, lines)
-		}
-	case yaml.DocumentNode:
-		for _, child := range node.Content {
-			normalizeNodeLeadingComments(child, lines)
-		}
+		return sortSequenceNodeWithPath(node, path, ctx, !excluded)
 	}
+	return nil
 }
 
-func normalizeMappingLeadingComments(node *yaml.Node, lines []string) {
+// sortMappingNodeWithPath sorts the mappings below node and, if reorder is
+// set, node's own keys.
+func sortMappingNodeWithPath(node *yaml.Node, path []pathSegment, ctx *sortContext, reorder bool) error {
 	if node.Kind != yaml.MappingNode || len(node.Content)%2 != 0 {
-		return
+		return nil
 	}
-
-	headComments := make([]string, 0, len(node.Content)/2)
-	for i := 0; i < len(node.Content); i += 2 {
-		key := node.Content[i]
-		head := extractLeadingCommentBlock(lines, key.Line)
-		headComments = append(headComments, head)
-		if head != "" {
-			key.HeadComment = head
+	kvPairs := extractKeyValuePairs(node)
+	frozen := make(map[*yaml.Node]bool)
+	resume := make(map[*yaml.Node]bool)
+	for _, p := range kvPairs {
+		d, skip, fz := ctx.entryDirectives(p.key.HeadComment, p.key.Line)
+		frozen[p.key], resume[p.key] = fz, d.resume
+		if skip {
+			continue
+		}
+		if d.order != nil {
+			ctx.pinOrder(p.value, d.order)
+		}
+		if err := sortNodeWithPath(p.value, append(path, keySegment(p.key.Value)), ctx); err != nil {
+			return err
 		}
 	}
-
-	for i := 0; i < len(node.Content)-2; i += 2 {
-		nextIdx := (i / 2) + 1
-		if headComments[nextIdx] == "" {
-			continue
+	if !reorder {
+		return nil
+	}
+	// Pinned key order (e.g. the K8s root order) where a rule applies;
+	// otherwise alphabetical. Stable, so keys with the same text (1 and "1")
+	// keep their source order.
+	// Merge keys ("<<: *base") always come first.
+	keyLess := func(a, b string) bool { return a < b }
+	if order, ok := ctx.keyOrderFor(node, path); ok {
+		keyLess = order.less
+	}
+	less := func(a, b kvPair) bool {
+		if ma, mb := isMergeKey(a.key), isMergeKey(b.key); ma != mb {
+			return ma
+		}
+		return keyLess(a.key.Value, b.key.Value)
+	}
+	keys := make([]*yaml.Node, len(kvPairs))
+	for i := range kvPairs {
+		keys[i] = kvPairs[i].key
+	}
+	sortAroundFrozen(kvPairs, func(p kvPair) bool { return frozen[p.key] }, func(p kvPair) bool { return resume[p.key] }, less)
+	for i, k := range keys {
+		if resume[k] {
+			moveResumeDirective(k, kvPairs[i].key)
 		}
-		node.Content[i].FootComment = ""
-		node.Content[i+1].FootComment = ""
 	}
+	rebuildMappingContent(node, kvPairs)
+	return nil
 }
 
-func normalizeSequenceLeadingComments(node *yaml.Node, lines []string) {
+// sortSequenceNodeWithPath sorts the nodes inside the list and, if reorder
+// is set and a list sort rule applies, the list itself.
+func sortSequenceNodeWithPath(node *yaml.Node, path []pathSegment, ctx *sortContext, reorder bool) error {
 	if node.Kind != yaml.SequenceNode {
-		return
+		return nil
+	}
+	// Recurse first, while each element's index still matches the source.
+	frozen := make(map[*yaml.Node]bool)
+	resume := make(map[*yaml.Node]bool)
+	for i, child := range node.Content {
+		d, skip, fz := ctx.entryDirectives(child.HeadComment, child.Line)
+		frozen[child], resume[child] = fz, d.resume
+		if skip {
+			continue
+		}
+		if d.order != nil {
+			ctx.pinOrder(child, d.order)
+		}
+		if err := sortNodeWithPath(child, append(path, indexSegment(i)), ctx); err != nil {
+			return err
+		}
 	}
-
-	headComments := make([]string, 0, len(node.Content))
-	for _, item := range node.Content {
-		head := extractLeadingCommentBlock(lines, item.Line)
-		headComments = append(headComments, head)
-		if head != "" {
-			item.HeadComment = head
+	rule, ok := ctx.listRuleFor(path)
+	if !reorder || !ok {
+		return nil
+	}
+	// Sort this list by each element's keys (e.g. "name")
+	keys := make(map[*yaml.Node]sortKey, len(node.Content))
+	for i, item := range node.Content {
+		if frozen[item] {
+			continue
+		}
+		k, err := rule.sortKey(item)
+		if err != nil {
+			return fmt.Errorf("%s: line %d: %w", formatPath(append(path, indexSegment(i))), item.Line, err)
+		}
+		keys[item] = k
+	}
+	ctx.reordered[formatPath(path)] = true
+	items := slices.Clone(node.Content)
+	sortAroundFrozen(node.Content, func(n *yaml.Node) bool { return frozen[n] }, func(n *yaml.Node) bool { return resume[n] }, func(a, b *yaml.Node) bool {
+		return rule.less(keys[a], keys[b])
+	})
+	for i, item := range items {
+		if resume[item] {
+			moveResumeDirective(item, node.Content[i])
 		}
 	}
+	return nil
+}
 
-	for i := 0; i < len(node.Content)-1; i++ {
-		if headComments[i+1] == "" {
+// sortAroundFrozen stable-sorts each run of items between frozen ones, so
+// frozen items (those in an off region) keep their position and nothing moves
+// across them. An item for which startsRun is true (the first one after an
+// "on" directive) begins a new run.
+func sortAroundFrozen[T any](items []T, frozen, startsRun func(T) bool, less func(a, b T) bool) {
+	start := 0
+	for i := 0; i <= len(items); i++ {
+		if i < len(items) && !frozen(items[i]) && (i == start || !startsRun(items[i])) {
 			continue
 		}
-		node.Content[i].FootComment = ""
+		run := items[start:i]
+		sort.SliceStable(run, func(a, b int) bool { return less(run[a], run[b]) })
+		start = i
+		if i < len(items) && frozen(items[i]) {
+			start++
+		}
+	}
+}
+
+type kvPair struct {
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+func extractKeyValuePairs(node *yaml.Node) []kvPair {
+	n := len(node.Content) / 2
+	pairs := make([]kvPair, 0, n)
+	for i := 0; i < len(node.Content); i += 2 {
+		pairs = append(pairs, kvPair{
+			key:   node.Content[i],
+			value: node.Content[i+1],
+		})
 	}
+	return pairs
 }
 
-func extractLeadingCommentBlock(lines []string, line int) string {
+func rebuildMappingContent(node *yaml.Node, pairs []kvPair) {
+	node.Content = make([]*yaml.Node, 0, len(pairs)*2)
+	for _, p := range pairs {
+		node.Content = append(node.Content, p.key, p.value)
+	}
+}
+
+// blankLineToken prefixes the marker that stands for a blank line in a head
+// comment, unless the input contains it (see uniqueToken).
+const blankLineToken = "#~ysort~blank~"
+
+// blankLines says how blank lines in comment blocks are written. The encoder
+// writes marker as a comment line, which restore then empties.
+type blankLines struct {
+	keep   bool // Options.KeepBlankLines
+	marker string
+}
+
+// extractLeadingCommentBlock returns the comment lines directly above line,
+// not looking above low (0-based). Blank lines inside the block become "#".
+// With blank.keep they become blank.marker instead, as does a blank line
+// above the block (or above the node when there is no comment) unless it
+// follows the start of the document.
+func extractLeadingCommentBlock(lines []string, line int, blank blankLines, low int) string {
 	if line <= 1 || line > len(lines) {
 		return ""
 	}
 
 	collected := make([]string, 0)
 	hasComment := false
-	for i := line - 2; i >= 0; i-- {
+	above := low > 0 // the block follows content rather than the document start
+	for i := line - 2; i >= low; i-- {
 		current := lines[i]
 		trimmed := strings.TrimSpace(current)
 		if trimmed == "" {
@@ -276,27 +654,83 @@ func extractLeadingCommentBlock(lines []string, line int) string {
 			continue
 		}
 
+		above = !isDocumentMarker(current) && !strings.HasPrefix(current, "%")
 		break
 	}
 
-	if !hasComment {
+	blankAbove := false
+	for len(collected) > 0 && collected[len(collected)-1] == "" {
+		collected = collected[:len(collected)-1]
+		blankAbove = true
+	}
+	keepAbove := blank.keep && blankAbove && above
+	if !hasComment && !keepAbove {
 		return ""
 	}
 
-	// We only keep blank separators that occur after at least one comment line.
-	for len(collected) > 0 && collected[len(collected)-1] == "" {
-		collected = collected[:len(collected)-1]
+	slices.Reverse(collected)
+	if keepAbove {
+		collected = append([]string{""}, collected...)
 	}
 
-	for i, j := 0, len(collected)-1; i < j; i, j = i+1, j-1 {
-		collected[i], collected[j] = collected[j], collected[i]
+	return blank.join(collected)
+}
+
+// join joins the lines of a comment block, writing blank lines as "#". With
+// keep they become marker, and a run of them one.
+func (b blankLines) join(lines []string) string {
+	blank := "#"
+	if b.keep {
+		blank = b.marker
+	}
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		switch {
+		case l != "":
+			out = append(out, l)
+		case b.keep && len(out) > 0 && out[len(out)-1] == blank:
+			// already separated
+		default:
+			out = append(out, blank)
+		}
 	}
+	return strings.Join(out, "\n")
+}
 
-	for i := range collected {
-		if collected[i] == "" {
-			collected[i] = "#"
+// dropMoved removes the kept blank line above a node that sorting moved to
+// the front of its mapping or list, where it would only separate the node
+// from its parent. A node that was already first keeps it. The blank lines
+// inside the moved node's comment go too: the next run would take the
+// comments above one for the top of the block (see bindStart).
+func (b blankLines) dropMoved(node *yaml.Node) {
+	step := 1
+	if node.Kind == yaml.MappingNode {
+		step = 2
+	}
+	if (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) && len(node.Content) > 0 {
+		first := node.Content[0]
+		for i := step; i < len(node.Content); i += step {
+			if node.Content[i].Line < first.Line {
+				lines := slices.DeleteFunc(strings.Split(first.HeadComment, "\n"), func(l string) bool {
+					return l == b.marker
+				})
+				first.HeadComment = strings.Join(lines, "\n")
+				break
+			}
 		}
 	}
+	for _, c := range node.Content {
+		b.dropMoved(c)
+	}
+}
 
-	return strings.Join(collected, "\n")
+// restore turns the marker comments in encoder output back into blank lines.
+func (b blankLines) restore(out []byte) []byte {
+	lines := strings.Split(string(out), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == b.marker {
+			lines[i] = ""
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
 }
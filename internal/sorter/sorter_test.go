@@ -3,6 +3,8 @@ package sorter
 import (
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestSortYAML(t *testing.T) {
@@ -34,9 +36,9 @@ banana: value`,
 			expected: `apple: value
 banana: value
 zebra:
-    a: value1
-    b: value2
-    c: value3
+  a: value1
+  b: value2
+  c: value3
 `,
 			wantErr: false,
 		},
@@ -221,7 +223,7 @@ func TestSortYAML_PreservesMultilineCommentBlockWithBlankLines(t *testing.T) {
 	}
 
 	out := string(result)
-	wantCommentBlock := "# this is the Cache Time To Live\n    #\n    # one hour is the default value\n    a: \"1\""
+	wantCommentBlock := "# this is the Cache Time To Live\n  #\n  # one hour is the default value\n  a: \"1\""
 	if !strings.Contains(out, wantCommentBlock) {
 		t.Fatalf("expected multiline comment block above a, got:\n%s", out)
 	}
@@ -234,3 +236,1316 @@ func TestSortYAML_PreservesMultilineCommentBlockWithBlankLines(t *testing.T) {
 		t.Fatalf("sort should remain idempotent with comment blocks")
 	}
 }
+
+func TestSortYAMLWithOptions_MultiDocument(t *testing.T) {
+	input := `# first document
+
+kind: ConfigMap
+apiVersion: v1
+---
+# second document
+
+spec:
+  z: 1
+  # attached to a
+  a: 2
+kind: Service
+apiVersion: v1
+`
+
+	result, err := SortYAMLWithOptions([]byte(input), Options{K8sRoot: true})
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+
+	want := `# first document
+
+apiVersion: v1
+kind: ConfigMap
+---
+# second document
+
+apiVersion: v1
+kind: Service
+spec:
+  # attached to a
+  a: 2
+  z: 1
+`
+	if string(result) != want {
+		t.Fatalf("multi-document sort mismatch:\ngot:\n%s\nwant:\n%s", result, want)
+	}
+}
+
+func TestSortYAMLWithOptions_Indentation(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "detects four spaces and indented sequences",
+			input: `spec:
+    z:
+        - b
+    a: 1
+`,
+			expected: `spec:
+    a: 1
+    z:
+        - b
+`,
+		},
+		{
+			name: "detects indentless sequences",
+			input: `spec:
+  containers:
+  - name: app
+    args:
+    - --b
+    # keep with a
+    - --a
+  replicas: 1
+`,
+			expected: `spec:
+  containers:
+  - args:
+    - --b
+    # keep with a
+    - --a
+    name: app
+  replicas: 1
+`,
+		},
+		{
+			name: "indentless sequences keep block scalars intact",
+			input: `steps:
+- script: |
+    - not a list item
+  name: build
+`,
+			expected: `steps:
+- name: build
+  script: |
+    - not a list item
+`,
+		},
+		{
+			name: "block scalars follow the new width",
+			input: `b: >
+  folded text
+  stays as written
+a:
+  - |-
+    literal
+`,
+			opts: Options{Indent: 4},
+			expected: `a:
+    - |-
+        literal
+b: >
+    folded text
+    stays as written
+`,
+		},
+		{
+			name: "options force width and sequence style",
+			input: `b:
+    - x
+a:
+    c: 1
+`,
+			opts: Options{Indent: 2, SequenceIndent: SequenceIndentIndentless},
+			expected: `a:
+  c: 1
+b:
+- x
+`,
+		},
+		{
+			name: "blocks inside list items take the forced width",
+			input: `items:
+  - name: a
+    ports:
+      - 80
+    limits:
+      cpu: 1
+`,
+			opts: Options{Indent: 4, SequenceIndent: SequenceIndentIndentless},
+			expected: `items:
+- limits:
+      cpu: 1
+  name: a
+  ports:
+  - 80
+`,
+		},
+		{
+			name:     "three spaces inside list items",
+			input:    "items:\n   - name: a\n     env:\n        z: 1\n        b: 2\n     args:\n        - x\n",
+			expected: "items:\n   - args:\n        - x\n     env:\n        b: 2\n        z: 1\n     name: a\n",
+		},
+		{
+			name:     "one-space input is written with two",
+			input:    "b:\n c: 1\na:\n - x\n",
+			opts:     Options{SequenceIndent: SequenceIndentIndentless},
+			expected: "a:\n- x\nb:\n  c: 1\n",
+		},
+		{
+			name:     "indent below the minimum",
+			input:    "b:\n  - x\na: 1\n",
+			opts:     Options{Indent: 1, SequenceIndent: SequenceIndentIndentless},
+			expected: "a: 1\nb:\n- x\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Fatalf("got:\n%s\nwant:\n%s", result, tt.expected)
+			}
+			again, err := SortYAMLWithOptions(result, tt.opts)
+			if err != nil {
+				t.Fatalf("second SortYAMLWithOptions() error = %v", err)
+			}
+			if string(again) != string(result) {
+				t.Fatalf("sort is not idempotent:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_ListSortKeyPatterns(t *testing.T) {
+	input := `spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          env:
+            - name: B
+            - name: A
+          ports:
+            - name: web
+            - name: admin
+      initContainers:
+        - name: init
+          env:
+            - name: D
+            - name: C
+`
+	opts := Options{
+		ListSortKeys: map[string]string{
+			"**.env":                                 "name",
+			"spec.template.spec.containers[*].ports": "name",
+		},
+	}
+	result, err := SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	out := string(result)
+	for _, pair := range [][2]string{{"name: A", "name: B"}, {"name: C", "name: D"}, {"name: admin", "name: web"}} {
+		if strings.Index(out, pair[0]) > strings.Index(out, pair[1]) {
+			t.Errorf("%q should come before %q:\n%s", pair[0], pair[1], out)
+		}
+	}
+}
+
+func TestSortYAMLWithOptions_MostSpecificListSortKeyWins(t *testing.T) {
+	input := `spec:
+  ports:
+    - name: b
+      port: 1
+    - name: a
+      port: 2
+`
+	opts := Options{
+		ListSortKeys: map[string]string{
+			"**.ports":   "name",
+			"spec.ports": "port",
+		},
+	}
+	result, err := SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	out := string(result)
+	if strings.Index(out, "port: 1") > strings.Index(out, "port: 2") {
+		t.Errorf("spec.ports should win over **.ports and sort by port:\n%s", out)
+	}
+
+	if _, err := SortYAMLWithOptions([]byte(input), Options{ListSortKeys: map[string]string{"spec.[": "name"}}); err == nil {
+		t.Error("invalid list sort path should be reported")
+	}
+}
+
+func TestSortYAMLWithOptions_ListSortRules(t *testing.T) {
+	input := `items:
+  - metadata:
+      name: b
+    protocol: TCP
+    port: 80
+  - protocol: UDP
+    port: 53
+  - metadata:
+      name: a
+    protocol: TCP
+    port: 443
+  - metadata:
+      name: a
+    protocol: TCP
+    port: 22
+`
+	tests := []struct {
+		name  string
+		rule  ListSortRule
+		order []string
+	}{
+		{
+			name:  "nested key, missing first",
+			rule:  ListSortRule{Path: "items", Keys: []string{"metadata.name", "port"}},
+			order: []string{"port: 53", "port: 22", "port: 443", "port: 80"},
+		},
+		{
+			name:  "nested key, missing last",
+			rule:  ListSortRule{Path: "items", Keys: []string{"metadata.name", "port"}, Missing: MissingLast},
+			order: []string{"port: 22", "port: 443", "port: 80", "port: 53"},
+		},
+		{
+			name:  "tie-breakers",
+			rule:  ListSortRule{Path: "items", Keys: []string{"protocol", "port"}},
+			order: []string{"port: 22", "port: 443", "port: 80", "port: 53"},
+		},
+		{
+			name:  "descending, missing still first",
+			rule:  ListSortRule{Path: "items", Keys: []string{"metadata.name", "port"}, Order: OrderDesc},
+			order: []string{"port: 53", "port: 80", "port: 443", "port: 22"},
+		},
+		{
+			name:  "numeric comparator",
+			rule:  ListSortRule{Path: "items", Keys: []string{"port"}, Compare: ComparatorNumeric},
+			order: []string{"port: 22", "port: 53", "port: 80", "port: 443"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(input), Options{ListSortRules: []ListSortRule{tt.rule}})
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			out := string(result)
+			for i := 1; i < len(tt.order); i++ {
+				if strings.Index(out, tt.order[i-1]) > strings.Index(out, tt.order[i]) {
+					t.Errorf("%q should come before %q:\n%s", tt.order[i-1], tt.order[i], out)
+				}
+			}
+		})
+	}
+}
+
+func TestLookupScalarDottedKeys(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte("metadata:\n  labels:\n    app.kubernetes.io/name: web\n  empty: null\n"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	root := doc.Content[0]
+	if v, ok := lookupScalar(root, strings.Split("metadata.labels.app.kubernetes.io/name", ".")); !ok || v != "web" {
+		t.Errorf("lookupScalar() = %q, %v; want web", v, ok)
+	}
+	for _, key := range []string{"metadata.empty", "metadata.labels", "metadata.missing"} {
+		if _, ok := lookupScalar(root, strings.Split(key, ".")); ok {
+			t.Errorf("lookupScalar(%q) should report the key as missing", key)
+		}
+	}
+}
+
+func TestSortYAMLWithOptions_SortByValue(t *testing.T) {
+	input := `rules:
+  - apiGroups: ["", apps]
+    verbs: [watch, get, list] # read-only
+    resources:
+      # pods first
+      - pods
+      # then deployments
+      - deployments
+      - configmaps # cm
+`
+	expected := `rules:
+  - apiGroups: ["", apps]
+    resources:
+      - configmaps # cm
+      # then deployments
+      - deployments
+      # pods first
+      - pods
+    verbs: [get, list, watch] # read-only
+`
+	opts := Options{ListSortRules: []ListSortRule{
+		{Path: "rules[*].verbs", By: SortByValue},
+		{Path: "**.resources", By: SortByValue},
+		{Path: "**.apiGroups", By: SortByValue},
+	}}
+	result, err := SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("SortYAMLWithOptions() =\n%s\nwant:\n%s", result, expected)
+	}
+
+	desc := Options{ListSortRules: []ListSortRule{{Path: "ports", By: SortByValue, Compare: ComparatorNumeric, Order: OrderDesc}}}
+	result, err = SortYAMLWithOptions([]byte("ports: [80, 443, http, 8080, ftp]\n"), desc)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if want := "ports: [8080, 443, 80, ftp, http]\n"; string(result) != want {
+		t.Errorf("descending with unparsed values = %q, want %q", result, want)
+	}
+
+	bad := Options{ListSortRules: []ListSortRule{{Path: "rules", By: SortByValue, Keys: []string{"name"}}}}
+	if _, err := SortYAMLWithOptions([]byte(input), bad); err == nil {
+		t.Error("keys with by value should be rejected")
+	}
+}
+
+func TestSortYAMLWithOptions_MissingKeyError(t *testing.T) {
+	input := `spec:
+  ports:
+    - name: web
+      port: 80
+    - port: 443
+`
+	opts := Options{ListSortRules: []ListSortRule{{Path: "spec.ports", Keys: []string{"name"}, Missing: MissingError}}}
+	_, err := SortYAMLWithOptions([]byte(input), opts)
+	if err == nil {
+		t.Fatal("expected an error for the element without name")
+	}
+	if want := `spec.ports[1]: line 5: element has no "name" to sort by`; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestSortYAMLWithOptions_TieBreak(t *testing.T) {
+	input := `items:
+  - name: a
+    port: 2
+  - name: b
+  - name: a
+    port: 1
+  - {port: 1, name: a} # same data as the third item
+`
+	tests := []struct {
+		name     string
+		tieBreak TieBreak
+		expected string
+	}{
+		{
+			name:     "source order",
+			tieBreak: TieBreakNone,
+			expected: `items:
+  - name: a
+    port: 2
+  - name: a
+    port: 1
+  - {name: a, port: 1} # same data as the third item
+  - name: b
+`,
+		},
+		{
+			name:     "content",
+			tieBreak: TieBreakContent,
+			expected: `items:
+  - name: a
+    port: 1
+  - {name: a, port: 1} # same data as the third item
+  - name: a
+    port: 2
+  - name: b
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{ListSortRules: []ListSortRule{{Path: "items", Keys: []string{"name"}, TieBreak: tt.tieBreak}}}
+			result, err := SortYAMLWithOptions([]byte(input), opts)
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAMLWithOptions() =\n%s\nwant:\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_KeyOrder(t *testing.T) {
+	input := `spec:
+  containers:
+    - securityContext: {}
+      env: []
+      args: [run]
+      image: app
+      name: app
+      resources: {}
+kind: Pod
+apiVersion: v1
+`
+	expected := `apiVersion: v1
+kind: Pod
+spec:
+  containers:
+    - name: app
+      image: app
+      args: [run]
+      env: []
+      resources: {}
+      securityContext: {}
+`
+	opts := Options{
+		K8sRoot: true,
+		KeyOrder: []KeyOrderRule{
+			{Path: "spec.containers[*]", First: []string{"name", "image", "command", "args"}, Last: []string{"securityContext"}},
+		},
+	}
+	result, err := SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("SortYAMLWithOptions() =\n%s\nwant:\n%s", result, expected)
+	}
+
+	// A root rule from the config replaces the built-in K8s order.
+	opts.KeyOrder = append(opts.KeyOrder, KeyOrderRule{Path: RootPath, Last: []string{"apiVersion"}})
+	result, err = SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if !strings.HasPrefix(string(result), "kind: Pod\nspec:") || !strings.HasSuffix(string(result), "apiVersion: v1\n") {
+		t.Errorf("root key order rule not applied:\n%s", result)
+	}
+
+	bad := Options{KeyOrder: []KeyOrderRule{{Path: "spec", First: []string{"a"}, Last: []string{"a"}}}}
+	if _, err := SortYAMLWithOptions([]byte(input), bad); err == nil {
+		t.Error("a key listed in both first and last should be rejected")
+	}
+}
+
+func TestSortYAMLWithOptions_Exclude(t *testing.T) {
+	input := `values:
+  zeta:
+    b: 1
+    a: 2
+  alpha: true
+other:
+  b: 1
+  a: 2
+`
+	tests := []struct {
+		name     string
+		rule     ExcludeRule
+		expected string
+	}{
+		{
+			name: "subtree",
+			rule: ExcludeRule{Path: "values"},
+			expected: `other:
+  a: 2
+  b: 1
+values:
+  zeta:
+    b: 1
+    a: 2
+  alpha: true
+`,
+		},
+		{
+			name: "node",
+			rule: ExcludeRule{Path: "values", Scope: ExcludeNode},
+			expected: `other:
+  a: 2
+  b: 1
+values:
+  zeta:
+    a: 2
+    b: 1
+  alpha: true
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(input), Options{Exclude: []ExcludeRule{tt.rule}})
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAMLWithOptions() =\n%s\nwant:\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseExcludeRule(t *testing.T) {
+	tests := []struct {
+		flag     string
+		expected ExcludeRule
+	}{
+		{"paths", ExcludeRule{Path: "paths"}},
+		{"spec.values:node", ExcludeRule{Path: "spec.values", Scope: ExcludeNode}},
+		{"spec.values:subtree", ExcludeRule{Path: "spec.values"}},
+		{"metadata.annotations.example.com/port:8080", ExcludeRule{Path: "metadata.annotations.example.com/port:8080"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseExcludeRule(tt.flag)
+		if err != nil || got != tt.expected {
+			t.Errorf("ParseExcludeRule(%q) = %+v, %v; want %+v", tt.flag, got, err, tt.expected)
+		}
+	}
+}
+
+func TestSortYAML_Directives(t *testing.T) {
+	input := `# ysort: ignore-file
+b: 1
+a: 2
+`
+	result, err := SortYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("SortYAML() error = %v", err)
+	}
+	if string(result) != input {
+		t.Errorf("ignore-file should leave the input unchanged, got:\n%s", result)
+	}
+
+	input = `items:
+  - b
+  # ysort: off
+  - z
+  - y
+  # ysort: on
+  - d
+  - c
+`
+	expected := `items:
+  - b
+  # ysort: off
+  - z
+  - y
+  # ysort: on
+  - c
+  - d
+`
+	opts := Options{ListSortRules: []ListSortRule{{Path: "items", By: SortByValue}}}
+	result, err = SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("SortYAMLWithOptions() =\n%s\nwant:\n%s", result, expected)
+	}
+
+	// Directive lookalikes in block scalars, flow collections and quoted
+	// scalars are text.
+	input = "b: |\n  # ysort: off\n  # ysort: whatever\n  text\nc: [\n  # ysort: off\n  1]\n" +
+		"d: \"x\n  # ysort: bogus\n  y\"\ne: 'x\n  # ysort: off\n  y'\nz: 1\na: 1\n"
+	expected = "a: 1\nb: |\n  # ysort: off\n  # ysort: whatever\n  text\nc: [\n  # ysort: off\n  1]\n" +
+		"d: \"x # ysort: bogus y\"\ne: 'x # ysort: off y'\nz: 1\n"
+	result, err = SortYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("SortYAML() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("SortYAML() =\n%s\nwant:\n%s", result, expected)
+	}
+
+	for _, tt := range []struct{ input, err string }{
+		{"a: 1\n# ysort: sort\nb: 2\n", `line 2: unknown ysort directive "sort"`},
+		{"# ysort: order=\na: 1\n", "line 1: ysort directive order needs keys, e.g. order=name,image"},
+		{"a: 1\n# ysort: ignore-file\n", "line 2: ysort directive ignore-file must come before any content"},
+	} {
+		if _, err := SortYAML([]byte(tt.input)); err == nil || err.Error() != tt.err {
+			t.Errorf("SortYAML(%q) error = %v, want %q", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestSortYAMLWithOptions_Anchors(t *testing.T) {
+	input := `zeta: &base
+  b: 1
+  a: 2
+alpha: *base
+mid:
+  name: x
+  <<: *base
+beta: *base
+`
+	expected := `alpha: &base
+  a: 2
+  b: 1
+beta: *base
+mid:
+  <<: *base
+  name: x
+zeta: *base
+`
+	result, err := SortYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("SortYAML() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("SortYAML() =\n%s\nwant:\n%s", result, expected)
+	}
+
+	_, err = SortYAMLWithOptions([]byte(input), Options{Anchors: AnchorError})
+	if want := "line 4: sorting would put alias *base before its anchor &base (line 1)"; err == nil || err.Error() != want {
+		t.Errorf("SortYAMLWithOptions() error = %v, want %q", err, want)
+	}
+	redefined := "b: &x 1\na: &x 2\nc: *x\n"
+	result, err = SortYAMLWithOptions([]byte(redefined), Options{Verify: true})
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if want := "a: &x_2 2\nb: &x 1\nc: *x_2\n"; string(result) != want {
+		t.Errorf("SortYAMLWithOptions() = %q, want %q", result, want)
+	}
+	_, err = SortYAMLWithOptions([]byte(redefined), Options{Anchors: AnchorError})
+	if want := "line 3: sorting would put anchor &x (line 1) between alias *x and its anchor (line 2)"; err == nil || err.Error() != want {
+		t.Errorf("SortYAMLWithOptions() error = %v, want %q", err, want)
+	}
+}
+
+func TestSortYAMLWithOptions_DuplicateKeys(t *testing.T) {
+	input := `spec:
+  containers:
+    - name: app
+      env: [a]
+      image: app
+      env: [b]
+`
+	tests := []struct {
+		name     string
+		dedupe   DedupePolicy
+		expected string
+		err      string
+	}{
+		{
+			name: "error",
+			err:  `spec.containers[0]: duplicate key "env" at lines 4 and 6`,
+		},
+		{
+			name:   "keep first",
+			dedupe: DedupeFirst,
+			expected: `spec:
+  containers:
+    - env: [a]
+      image: app
+      name: app
+`,
+		},
+		{
+			name:   "keep last",
+			dedupe: DedupeLast,
+			expected: `spec:
+  containers:
+    - env: [b]
+      image: app
+      name: app
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(input), Options{Dedupe: tt.dedupe})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("SortYAMLWithOptions() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAMLWithOptions() =\n%s\nwant:\n%s", result, tt.expected)
+			}
+		})
+	}
+
+	if _, err := SortYAML([]byte("1: int\n\"1\": string\n")); err != nil {
+		t.Errorf("keys with different tags are not duplicates: %v", err)
+	}
+}
+
+func TestSortYAMLWithOptions_KeepBlankLines(t *testing.T) {
+	input := `# file comment
+
+zeta: 1
+
+beta:
+  y: 2
+
+  x: 1
+# about alpha
+
+# more about alpha
+alpha:
+  - c
+
+  - a
+`
+	tests := []struct {
+		name     string
+		keep     bool
+		expected string
+	}{
+		{
+			name: "dropped by default",
+			expected: `# file comment
+
+# about alpha
+#
+# more about alpha
+alpha:
+  - c
+  - a
+beta:
+  x: 1
+  y: 2
+zeta: 1
+`,
+		},
+		{
+			name: "kept and moved with the node",
+			keep: true,
+			expected: `# file comment
+
+# about alpha
+# more about alpha
+alpha:
+  - c
+
+  - a
+
+beta:
+  x: 1
+  y: 2
+zeta: 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{KeepBlankLines: tt.keep}
+			result, err := SortYAMLWithOptions([]byte(input), opts)
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Fatalf("got:\n%s\nwant:\n%s", result, tt.expected)
+			}
+			again, err := SortYAMLWithOptions(result, opts)
+			if err != nil {
+				t.Fatalf("second SortYAMLWithOptions() error = %v", err)
+			}
+			if string(again) != string(result) {
+				t.Fatalf("sort is not idempotent:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_MoveBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+		err      string
+	}{
+		{
+			name: "unmoved lines stay as written",
+			input: `# header
+
+zeta:   1   # spaced
+beta:
+    # about y
+    y: "two"
+    x:    'one'
+
+alpha: [ ]
+`,
+			expected: `# header
+
+alpha: [ ]
+beta:
+    x:    'one'
+    # about y
+    y: "two"
+
+zeta:   1   # spaced
+`,
+		},
+		{
+			name: "first key of a list item",
+			input: `items:
+- name: b
+  # about args
+  args: [x]
+- name: a
+`,
+			expected: `items:
+- # about args
+  args: [x]
+  name: b
+- name: a
+`,
+		},
+		{
+			name:     "comment at the top of a block",
+			input:    "m:\n  # top\n\n  b: 1\n  a: 2\n",
+			expected: "m:\n  # top\n\n  a: 2\n  b: 1\n",
+		},
+		{
+			name:     "deeper comment above a key that moves down",
+			input:    "m:\n    # about b\n  b: 1\n  a: 2\n",
+			expected: "m:\n  a: 2\n  # about b\n  b: 1\n",
+		},
+		{
+			name:     "blank line in the comments of a key that moves to the top",
+			input:    "m:\n  b: 1\n  # about a\n\n  # more\n  a: 2\n",
+			expected: "m:\n  # about a\n  # more\n  a: 2\n  b: 1\n",
+		},
+		{
+			name: "list sort rule and flow mapping",
+			input: `items:
+  - name: b
+    env: {z: 1, a: 2}   # kept
+  - name: a
+`,
+			opts: Options{ListSortKeys: map[string]string{"items": "name"}},
+			expected: `items:
+  - name: a
+  - env: {a: 2, z: 1}   # kept
+    name: b
+`,
+		},
+		{
+			name: "block scalars move as written",
+			input: `b: >
+  folded
+  text
+a: |+
+  kept
+
+c: 1
+`,
+			expected: `a: |+
+  kept
+
+b: >
+  folded
+  text
+c: 1
+`,
+		},
+		{
+			name:  "alias before anchor",
+			input: "b: &x 1\na: *x\n",
+			err:   "line 2: sorting would put alias *x before its anchor &x (line 1); moving blocks cannot move the anchor",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.MoveBlocks = true
+			result, err := SortYAMLWithOptions([]byte(tt.input), opts)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("SortYAMLWithOptions() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Fatalf("got:\n%s\nwant:\n%s", result, tt.expected)
+			}
+			again, err := SortYAMLWithOptions(result, opts)
+			if err != nil {
+				t.Fatalf("second SortYAMLWithOptions() error = %v", err)
+			}
+			if string(again) != string(result) {
+				t.Fatalf("sort is not idempotent:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_LineEndings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "crlf kept",
+			input:    "b: 1\r\n# about a\r\na: 2\r\n",
+			expected: "# about a\r\na: 2\r\nb: 1\r\n",
+		},
+		{
+			name:     "byte order mark and missing final newline kept",
+			input:    "\xef\xbb\xbfb: 1\na: 2",
+			expected: "\xef\xbb\xbfa: 2\nb: 1",
+		},
+		{
+			name:     "block scalar with crlf",
+			input:    "b: |\r\n  text\r\na: 1\r\n",
+			expected: "a: 1\r\nb: |\r\n  text\r\n",
+		},
+		{
+			name:     "forced lf",
+			input:    "b: 1\r\na: 2\r\n",
+			opts:     Options{LineEndings: LineEndingsLF},
+			expected: "a: 2\nb: 1\n",
+		},
+		{
+			name:     "forced crlf",
+			input:    "b: 1\na: 2\n",
+			opts:     Options{LineEndings: LineEndingsCRLF},
+			expected: "a: 2\r\nb: 1\r\n",
+		},
+		{
+			name:     "moving blocks",
+			input:    "\xef\xbb\xbfb:  1\r\na:  2",
+			opts:     Options{MoveBlocks: true},
+			expected: "\xef\xbb\xbfa:  2\r\nb:  1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAMLWithOptions() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_DocumentFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "modeline stays first",
+			input:    "# yaml-language-server: $schema=schema.json\nb: 1\na: 2\n",
+			expected: "# yaml-language-server: $schema=schema.json\na: 2\nb: 1\n",
+		},
+		{
+			name:     "modeline after the marker",
+			input:    "---\n# yaml-language-server: $schema=schema.json\nkind: Pod\napiVersion: v1\n",
+			opts:     Options{K8sRoot: true},
+			expected: "---\n# yaml-language-server: $schema=schema.json\napiVersion: v1\nkind: Pod\n",
+		},
+		{
+			name:     "directives and markers",
+			input:    "%YAML 1.2\n# about the file\n--- # config\nb: 1\na: 2\n...\n",
+			expected: "%YAML 1.2\n# about the file\n--- # config\na: 2\nb: 1\n...\n",
+		},
+		{
+			name:     "comments above the next document stay there",
+			input:    "b: 1\na: 2\n# next: the service\n---\nd: 1\nc: 2\n",
+			expected: "a: 2\nb: 1\n# next: the service\n---\nc: 2\nd: 1\n",
+		},
+		{
+			name:     "indented comment above the next document stays in its block",
+			input:    "z: 1\nm:\n  b: 1\n  # foot m\n# next\n---\nc: 1\n",
+			expected: "m:\n  b: 1\n  # foot m\nz: 1\n# next\n---\nc: 1\n",
+		},
+		{
+			name:     "indented comment above the document end stays in its block",
+			input:    "z: 1\nlist:\n  - b\n  - a\n  # foot list\n...\n",
+			expected: "list:\n  - b\n  - a\n  # foot list\nz: 1\n...\n",
+		},
+		{
+			name:     "tag handles",
+			input:    "%TAG !e! tag:example.com,2000:\n---\nb: !e!x 1\na: 2\n",
+			expected: "%TAG !e! tag:example.com,2000:\n---\na: 2\nb: !e!x 1\n",
+		},
+		{
+			name:     "document end marker and trailing comment",
+			input:    "b: 1\na: 2\n...\n# end\n",
+			expected: "a: 2\nb: 1\n...\n# end\n",
+		},
+		{
+			name:     "empty stream",
+			input:    "",
+			expected: "",
+		},
+		{
+			name:     "comment-only stream",
+			input:    "\n# only a comment\n\n",
+			expected: "\n# only a comment\n\n",
+		},
+		{
+			name:     "bare document marker",
+			input:    "# values\n---\n",
+			expected: "# values\n---\n",
+		},
+		{
+			name:     "comment-only document",
+			input:    "a: 1\n---\n# Source: empty\n---\nb: 1\n",
+			expected: "a: 1\n---\n# Source: empty\n---\nb: 1\n",
+		},
+		{
+			name:     "comment-only last document",
+			input:    "b: 1\na: 2\n---\n# c\n\n# d\n",
+			expected: "a: 2\nb: 1\n---\n# c\n\n# d\n",
+		},
+		{
+			name:     "empty last document",
+			input:    "b: 1\na: 2\n---\n",
+			expected: "a: 2\nb: 1\n---\n",
+		},
+		{
+			name:     "document comment after an explicit marker",
+			input:    "---\n# about the document\n\nb: 1\na: 2\n",
+			expected: "---\n# about the document\n\na: 2\nb: 1\n",
+		},
+		{
+			name:     "directive above the marker",
+			input:    "# ysort: ignore\n---\nb: 1\na: 2\n",
+			expected: "# ysort: ignore\n---\nb: 1\na: 2\n",
+		},
+		{
+			name:     "moving blocks",
+			input:    "%YAML 1.2\n---\nb:  1\na:  2\n...\n",
+			opts:     Options{MoveBlocks: true},
+			expected: "%YAML 1.2\n---\na:  2\nb:  1\n...\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAMLWithOptions() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTagShortener(t *testing.T) {
+	var tags tagShortener
+	tags.add("tag:example.com,2000:", "!e.x!")
+	tags.add("tag:other.org:", "!$1!")
+	got := tags.shorten("a: !<tag:example.com,2000:y> 1\nb: !<tag:other.org:z> 2\n")
+	if want := "a: !e.x!y 1\nb: !$1!z 2\n"; got != want {
+		t.Errorf("shorten() = %q, want %q", got, want)
+	}
+}
+
+func TestSortYAMLWithOptions_Comments(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "trailing comment of the document",
+			input:    "b: 1\na: 2\n# trailing root comment\n",
+			expected: "a: 2\nb: 1\n# trailing root comment\n",
+		},
+		{
+			name:     "comment at the end of a mapping",
+			input:    "m:\n  b: 1\n  a: 2\n  # end of m\nz: 1\n",
+			expected: "m:\n  a: 2\n  b: 1\n  # end of m\nz: 1\n",
+		},
+		{
+			name:     "comment at the end of a moved mapping",
+			input:    "m:\n  b:\n    y: 1\n    x: 2\n    # end of b\n\n  # about a\n  a: 1\n",
+			expected: "m:\n  # about a\n  a: 1\n  b:\n    x: 2\n    y: 1\n    # end of b\n",
+		},
+		{
+			name:     "comment at the top of a block",
+			input:    "m:\n  # about the block\n\n  b: 1\n  a: 2\n",
+			expected: "m:\n  # about the block\n\n  a: 2\n  b: 1\n",
+		},
+		{
+			name:     "comment at the top of a block above the first key",
+			input:    "m:\n  # about the block\n\n  # about a\n  a: 1\n  b: 2\n",
+			expected: "m:\n  # about the block\n\n  # about a\n  a: 1\n  b: 2\n",
+		},
+		{
+			name:     "comment after the last list item",
+			input:    "l:\n- b\n- a\n# after the list\nz: 1\n",
+			expected: "l:\n- b\n- a\n# after the list\nz: 1\n",
+		},
+		{
+			name:     "comment at the end of a list item",
+			input:    "l:\n  - name: b\n    x: 1\n    # end of b\n  - name: a\nz: 1\n",
+			expected: "l:\n  - name: b\n    x: 1\n    # end of b\n  - name: a\nz: 1\n",
+		},
+		{
+			name:     "comment above the first key of a list item",
+			input:    "containers:\n  - name: nginx\n    # c30\n    image: nginx\n",
+			expected: "containers:\n  - # c30\n    image: nginx\n    name: nginx\n",
+		},
+		{
+			name:     "comment after the dash stays with the first key",
+			input:    "l:\n  - # about b\n    b: 1\n    a: 2\n",
+			expected: "l:\n  - a: 2\n    # about b\n    b: 1\n",
+		},
+		{
+			name:     "hash line inside a double-quoted scalar",
+			input:    "z: 1\nb: \"multi\n  # not comment\n  end\"\na: 1\n",
+			expected: "a: 1\nb: \"multi # not comment end\"\nz: 1\n",
+		},
+		{
+			name:     "comment after a single-quoted scalar that spans lines",
+			input:    "b: 'x # no\n  # no\n  y' # yes\na: 1\n",
+			expected: "a: 1\nb: 'x # no # no y' # yes\n",
+		},
+		{
+			name:     "comment between a key and its empty value",
+			input:    "b:\n  # only a comment\na: 1\n",
+			expected: "a: 1\nb:\n  # only a comment\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAMLWithOptions([]byte(tt.input), Options{})
+			if err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAMLWithOptions() = %q, want %q", result, tt.expected)
+			}
+			again, err := SortYAMLWithOptions(result, Options{})
+			if err != nil {
+				t.Fatalf("second SortYAMLWithOptions() error = %v", err)
+			}
+			if string(again) != tt.expected {
+				t.Errorf("second SortYAMLWithOptions() = %q, want %q", again, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_DebugComments(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "document",
+			input: "# about the file\n\nm:\n  b: 1 # one\n  # about a\n  a: 2\n  # end of m\n",
+			want: "1: # about the file -> top of the document\n" +
+				"4: # one -> after m.b\n" +
+				"5: # about a -> above m.a\n" +
+				"7: # end of m -> end of m\n",
+		},
+		{
+			name:  "foot comment before the end marker",
+			input: "b: 1\na: 2\n# foot\n...\n",
+			want:  "3: # foot -> after the document\n",
+		},
+		{
+			name:  "foot comment before the next document",
+			input: "b: 1\n# foot\n---\n# top\na: 2\n",
+			want: "2: # foot -> after the document (document 1)\n" +
+				"4: # top -> above a (document 2)\n",
+		},
+		{
+			name:  "comment at the column of an indentless list",
+			input: "c:\n  x:\n  - name: web\n    env:\n    - name: M\n    # end of x[0]\n  b: 1\n",
+			want:  "6: # end of x[0] -> end of c.x[0]\n",
+		},
+		{
+			name:  "comment at the column of an indentless list at the root",
+			input: "b:\n- 1\n# end\n",
+			want:  "3: # end -> end of the document\n",
+		},
+		{
+			name:  "comment before the first document",
+			input: "# before\n---\nb: 1\n",
+			want:  "1: # before -> before the document\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trace strings.Builder
+			if _, err := SortYAMLWithOptions([]byte(tt.input), Options{DebugComments: &trace}); err != nil {
+				t.Fatalf("SortYAMLWithOptions() error = %v", err)
+			}
+			if trace.String() != tt.want {
+				t.Errorf("trace = %q, want %q", trace.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSortYAML_PlaceholderLookalikes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "block scalar placeholder",
+			input:    "c: ysort_block_scalar_0\nb: |\n  hello\na: 1\n",
+			expected: "a: 1\nb: |\n  hello\nc: ysort_block_scalar_0\n",
+		},
+		{
+			name:     "escaped block scalar placeholder",
+			input:    "c: \"ysort\\x5fblock_scalar_0\"\nb: |\n  hello\n",
+			expected: "b: |\n  hello\nc: \"ysort_block_scalar_0\"\n",
+		},
+		{
+			name:     "comment placeholder",
+			input:    "b: ysort_comment_1\na:\n  y: 1\n  x: 2\n  # end of a\n",
+			expected: "a:\n  x: 2\n  y: 1\n  # end of a\nb: ysort_comment_1\n",
+		},
+		{
+			name:     "comment placeholder in a comment",
+			input:    "b: 1 # ysort_comment_1\na: 2\n",
+			expected: "a: 2\nb: 1 # ysort_comment_1\n",
+		},
+		{
+			name:     "blank line marker",
+			input:    "b: 1\n#~ysort~blank~\na: 2\n",
+			expected: "#~ysort~blank~\na: 2\nb: 1\n",
+		},
+		{
+			name:     "blank line marker at the top of a block",
+			input:    "m:\n  #~ysort~blank~\n\n  b: 1\n  a: 2\n",
+			expected: "m:\n  #~ysort~blank~\n\n  a: 2\n  b: 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SortYAML([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("SortYAML() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("SortYAML() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package sorter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// verifyOutput decodes the input lines src and the sorted output again and
+// checks that they hold the same data. Mapping order does not count, and
+// neither does the order of the lists in reordered, one set of source paths
+// per document. Duplicate keys in the input are resolved by policy first.
+func verifyOutput(src []string, out []byte, reordered []map[string]bool, policy DedupePolicy) error {
+	before, err := decodeStream(src)
+	if err != nil {
+		return err
+	}
+	after, err := decodeStream(strings.Split(string(out), "\n"))
+	if err != nil {
+		return fmt.Errorf("verify: the output is not valid YAML: %w", err)
+	}
+	if len(before) != len(after) {
+		return fmt.Errorf("verify: the output has %d documents instead of %d", len(after), len(before))
+	}
+	for i := range before {
+		if err := checkDuplicateKeys(before[i], nil, policy); err != nil {
+			return err
+		}
+		v := verifier{reordered: reordered[i]}
+		if err := v.equal(before[i].Content[0], after[i].Content[0], nil); err != nil {
+			if len(before) > 1 {
+				return fmt.Errorf("verify: document %d: %w", i+1, err)
+			}
+			return fmt.Errorf("verify: %w", err)
+		}
+	}
+	return nil
+}
+
+// decodeStream decodes lines the way SortYAMLWithOptions does, without the
+// frames around the documents.
+func decodeStream(lines []string) ([]*yaml.Node, error) {
+	_, view := splitFrames(lines)
+	return decodeDocuments([]byte(strings.Join(view, "\n")))
+}
+
+// verifier compares a node of the input with the node that replaced it in
+// the output.
+type verifier struct {
+	reordered map[string]bool // paths of the lists a list sort rule reordered
+}
+
+// equal reports the first difference between a and b, which are at path in
+// the input; aliases are compared by what they point to.
+func (v verifier) equal(a, b *yaml.Node, path []pathSegment) error {
+	for a.Kind == yaml.AliasNode {
+		a = a.Alias
+	}
+	for b.Kind == yaml.AliasNode {
+		b = b.Alias
+	}
+	if a.Kind != b.Kind {
+		return differsAt(path, "%s became %s", kindName(a), kindName(b))
+	}
+	switch a.Kind {
+	case yaml.ScalarNode:
+		if a.ShortTag() != b.ShortTag() || a.Value != b.Value {
+			return differsAt(path, "%s %q became %s %q", a.ShortTag(), a.Value, b.ShortTag(), b.Value)
+		}
+	case yaml.MappingNode:
+		return v.equalMappings(a, b, path)
+	case yaml.SequenceNode:
+		if len(a.Content) != len(b.Content) {
+			return differsAt(path, "%d items became %d", len(a.Content), len(b.Content))
+		}
+		if v.reordered[formatPath(path)] {
+			return v.equalSorted(a, b, path)
+		}
+		for i := range a.Content {
+			if err := v.equal(a.Content[i], b.Content[i], append(path, indexSegment(i))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// equalMappings compares the mappings a and b, at path in the input, key by
+// key.
+func (v verifier) equalMappings(a, b *yaml.Node, path []pathSegment) error {
+	values := make(map[string]*yaml.Node, len(b.Content)/2)
+	for i := 0; i+1 < len(b.Content); i += 2 {
+		values[canonicalContent(b.Content[i])] = b.Content[i+1]
+	}
+	for i := 0; i+1 < len(a.Content); i += 2 {
+		key := a.Content[i]
+		id := canonicalContent(key)
+		value, ok := values[id]
+		if !ok {
+			return differsAt(append(path, keySegment(key.Value)), "key is missing")
+		}
+		if err := v.equal(a.Content[i+1], value, append(path, keySegment(key.Value))); err != nil {
+			return err
+		}
+		delete(values, id)
+	}
+	for i := 0; i+1 < len(b.Content); i += 2 {
+		if _, extra := values[canonicalContent(b.Content[i])]; extra {
+			return differsAt(append(path, keySegment(b.Content[i].Value)), "key was added")
+		}
+	}
+	return nil
+}
+
+// equalSorted compares the lists a and b, at path in the input, as multisets:
+// every item of a must have its own equal item in b. Only items with the same
+// unorderedContent can be equal, so each item is compared with those alone.
+func (v verifier) equalSorted(a, b *yaml.Node, path []pathSegment) error {
+	candidates := make(map[string][]*yaml.Node)
+	for _, item := range b.Content {
+		id := unorderedContent(item)
+		candidates[id] = append(candidates[id], item)
+	}
+	for i, item := range a.Content {
+		id := unorderedContent(item)
+		found := slices.IndexFunc(candidates[id], func(c *yaml.Node) bool {
+			return v.equal(item, c, append(path, indexSegment(i))) == nil
+		})
+		if found < 0 {
+			return differsAt(append(path, indexSegment(i)), "item is missing after sorting the list")
+		}
+		candidates[id] = slices.Delete(candidates[id], found, found+1)
+	}
+	return nil
+}
+
+// unorderedContent is canonicalContent with the items of every list sorted,
+// so an item keeps it when sorting reorders the lists inside it.
+func unorderedContent(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return unorderedContent(node.Alias)
+	case yaml.SequenceNode:
+		items := make([]string, len(node.Content))
+		for i, c := range node.Content {
+			items[i] = unorderedContent(c)
+		}
+		sort.Strings(items)
+		return "[" + strings.Join(items, ",") + "]"
+	case yaml.MappingNode:
+		entries := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			entries = append(entries, unorderedContent(node.Content[i])+":"+unorderedContent(node.Content[i+1]))
+		}
+		sort.Strings(entries)
+		return "{" + strings.Join(entries, ",") + "}"
+	}
+	return canonicalContent(node)
+}
+
+func differsAt(path []pathSegment, format string, args ...any) error {
+	where := formatPath(path)
+	if where == "" {
+		where = "the document root"
+	}
+	return fmt.Errorf("%s: %s", where, fmt.Sprintf(format, args...))
+}
+
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return "a scalar"
+}
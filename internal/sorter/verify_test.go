@@ -0,0 +1,172 @@
+package sorter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		output    string
+		reordered []string // paths of the lists a rule sorted
+		wantErr   string   // "" for none
+	}{
+		{
+			name:   "mapping order",
+			input:  "b: 1\na: {d: 2, c: 3}\n",
+			output: "a: {c: 3, d: 2}\nb: 1\n",
+		},
+		{
+			name:      "sorted list",
+			input:     "l:\n  - name: b\n  - name: a\n",
+			output:    "l:\n  - name: a\n  - name: b\n",
+			reordered: []string{"l"},
+		},
+		{
+			name:      "sorted lists inside a sorted list",
+			input:     "l:\n  - {n: b, v: [2, 1]}\n  - {n: a, v: [4, 3]}\n",
+			output:    "l:\n  - {n: a, v: [3, 4]}\n  - {n: b, v: [1, 2]}\n",
+			reordered: []string{"l", "l[0].v", "l[1].v"},
+		},
+		{
+			name:    "list order",
+			input:   "l: [b, a]\n",
+			output:  "l: [a, b]\n",
+			wantErr: `verify: l[0]: !!str "b" became !!str "a"`,
+		},
+		{
+			name:      "item changed in a sorted list",
+			input:     "l: [b, a]\n",
+			output:    "l: [a, c]\n",
+			reordered: []string{"l"},
+			wantErr:   "verify: l[0]: item is missing after sorting the list",
+		},
+		{
+			name:    "scalar type",
+			input:   "a: \"1\"\n",
+			output:  "a: 1\n",
+			wantErr: `verify: a: !!str "1" became !!int "1"`,
+		},
+		{
+			name:    "missing key",
+			input:   "a: 1\nb: {c: 2}\n",
+			output:  "a: 1\nb: {}\n",
+			wantErr: "verify: b.c: key is missing",
+		},
+		{
+			name:   "anchor moved to the first alias",
+			input:  "b: &x {q: 1}\na: *x\n",
+			output: "a: &x {q: 1}\nb: *x\n",
+		},
+		{
+			name:    "second document",
+			input:   "a: 1\n---\nb: 1\n",
+			output:  "a: 1\n---\nb: 2\n",
+			wantErr: `verify: document 2: b: !!int "1" became !!int "2"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := strings.Split(tt.input, "\n")
+			reordered := make([]map[string]bool, strings.Count(tt.input, "---")+1)
+			for i := range reordered {
+				reordered[i] = make(map[string]bool)
+			}
+			for _, p := range tt.reordered {
+				reordered[0][p] = true
+			}
+			err := verifyOutput(src, []byte(tt.output), reordered, DedupeOff)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyOutput() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("verifyOutput() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSortYAMLWithOptions_Verify(t *testing.T) {
+	input := "items:\n  - name: b\n    verbs: [watch, get]\n  - name: a\nother: [3, 1]\n"
+	opts := Options{
+		Verify: true,
+		ListSortRules: []ListSortRule{
+			{Path: "items", Keys: []string{"name"}},
+			{Path: "**.verbs", By: SortByValue},
+		},
+	}
+	expected := "items:\n  - name: a\n  - name: b\n    verbs: [get, watch]\nother: [3, 1]\n"
+	result, err := SortYAMLWithOptions([]byte(input), opts)
+	if err != nil {
+		t.Fatalf("SortYAMLWithOptions() error = %v", err)
+	}
+	if string(result) != expected {
+		t.Errorf("SortYAMLWithOptions() = %q, want %q", result, expected)
+	}
+}
+
+func TestCommentSetVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		output  string
+		wantErr string // "" for none
+	}{
+		{
+			name:   "comments in place",
+			input:  "b: 1\n# about a\na: 2\n# foot\n...\n",
+			output: "b: 1\n# about a\na: 2\n# foot\n...\n",
+		},
+		{
+			name:    "head comment taken for the foot",
+			input:   "b: 1\n# about a\na: 2\n# foot\n...\n",
+			output:  "b: 1\na: 2\n# about a\n# foot\n...\n",
+			wantErr: `line 2: comment "# about a" would move from above a to after the document`,
+		},
+		{
+			name:    "foot comment before the end marker",
+			input:   "a: 1\n# foot\n...\n",
+			output:  "# foot\na: 1\n...\n",
+			wantErr: `line 2: comment "# foot" would move from after the document to above a`,
+		},
+		{
+			name:    "foot comment before the next document",
+			input:   "a: 1\n# foot\n---\nb: 2\n",
+			output:  "a: 1\n---\n# foot\nb: 2\n",
+			wantErr: `line 2: comment "# foot" would move from after the document (document 1) to above b (document 2)`,
+		},
+		{
+			name:    "indented comment above the next document",
+			input:   "m:\n  a: 1\n  # end of m\n---\nb: 2\n",
+			output:  "m:\n  a: 1\n# end of m\n---\nb: 2\n",
+			wantErr: `line 3: comment "# end of m" would move from end of m (document 1) to after the document (document 1)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := strings.Split(tt.input, "\n")
+			_, lines := splitFrames(src)
+			docs, err := decodeDocuments([]byte(strings.Join(lines, "\n")))
+			if err != nil {
+				t.Fatalf("decodeDocuments() error = %v", err)
+			}
+			blank := blankLines{marker: blankLineToken}
+			attachDocumentComments(docs, lines, blank)
+			err = bindComments(docs, src, lines, blank).verify([]byte(tt.output), docs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}